@@ -10,11 +10,33 @@ type Conf struct {
 	LogFormat string
 	APIToken  string
 	SkipPush  bool
+	DataDir   string
+	Workers   int
+
+	// number of jobs for the same repo processed at once
+	RepoConcurrency int
+
+	// for returning the job in flight instead of building the same thing twice
+	DedupJobs bool
+
+	// default job timeout, e.g. "30m"
+	JobTimeout string
+
+	// for retrying clones and pushes, e.g. 3 and "2s"
+	RetryAttempts int
+	RetryBackoff  string
+
+	// for job notifications
+	NotifyURLs   []string
+	NotifySecret string
 
 	// for basic auth
 	Username string
 	Password string
 
+	// JSON file of named API tokens
+	AuthTokens string
+
 	// for travis auth
 	TravisToken string
 	NoTravis    bool
@@ -22,6 +44,38 @@ type Conf struct {
 	// for github auth
 	GitHubSecret string
 	NoGitHub     bool
+	GitHubAPIURL string
+
+	// for gitlab auth
+	GitLabToken string
+	NoGitLab    bool
+
+	// for bitbucket auth
+	BitbucketSecret string
+	NoBitbucket     bool
+
+	// for gitea auth
+	GiteaSecret string
+	NoGitea     bool
+
+	// for cloning from hosts that need credentials
+	GitCredentials string
+
+	// for removing old jobs, e.g. "72h", 500 and "20GB"
+	RetentionMaxAge  string
+	RetentionMaxJobs int
+	RetentionMaxDisk string
+	JanitorInterval  string
+
+	// free space the readiness check expects for workdirs and logs, e.g. "1GB"
+	ReadyMinFreeDisk string
+
+	// for caching clones between jobs
+	CloneCacheDir string
+	CloneCache    string
+
+	// for filtering webhooks by branch or tag
+	RefRules string
 
 	// docker registry credentials
 	CfgUn    string
@@ -3,7 +3,9 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -13,6 +15,7 @@ import (
 	"github.com/rafecolton/go-gitutils"
 
 	"github.com/codegangsta/cli"
+	"github.com/moby/moby/pkg/archive"
 	"github.com/onsi/gocleanup"
 )
 
@@ -20,7 +23,9 @@ import (
 type EnqueueOptions struct {
 	Bobfile string
 	Host    string
+	Token   string
 	Top     string
+	Upload  bool
 }
 
 // Enqueuer is a struct that handles parsing the repo data and making the
@@ -31,6 +36,9 @@ type Enqueuer struct {
 	host    string
 	ref     string
 	repo    string
+	token   string
+	top     string
+	upload  bool
 }
 
 func enqueue(c *cli.Context) {
@@ -40,13 +48,17 @@ func enqueue(c *cli.Context) {
 		bobfile = "Bobfile"
 	}
 
-	if !git.IsClean(top) {
+	// an upload builds exactly what's in the working directory, so it doesn't
+	// matter whether or not it matches the remote
+	var upload = c.Bool("upload")
+
+	if !upload && !git.IsClean(top) {
 		Logger.Error("cannot enqueue, working directory is dirty")
 		gocleanup.Exit(1)
 	}
 
 	upToDate := git.UpToDate(top)
-	if upToDate != git.StatusUpToDate {
+	if !upload && upToDate != git.StatusUpToDate {
 		switch upToDate {
 		case git.StatusNeedToPull:
 			Logger.Warn("CAUTION: need to pull")
@@ -61,8 +73,10 @@ func enqueue(c *cli.Context) {
 	var host = c.String("host")
 	opts := EnqueueOptions{
 		Host:    host,
+		Token:   c.String("token"),
 		Bobfile: bobfile,
 		Top:     top,
+		Upload:  upload,
 	}
 	enqueuer := NewEnqueuer(opts)
 	result, err := enqueuer.Enqueue()
@@ -83,6 +97,9 @@ func NewEnqueuer(options EnqueueOptions) *Enqueuer {
 		host:    options.Host,
 		ref:     git.Branch(options.Top),
 		repo:    filepath.Base(options.Top),
+		token:   options.Token,
+		top:     options.Top,
+		upload:  options.Upload,
 	}
 }
 
@@ -104,6 +121,9 @@ func (enc *Enqueuer) BodyBytes() ([]byte, error) {
 // RequestPath returns the path to which enqueue requests are sent.  This
 // includes both the host and the route
 func (enc *Enqueuer) RequestPath() string {
+	if enc.upload {
+		return enc.host + server.JobRoute
+	}
 	return enc.host + server.BuildRoute
 }
 
@@ -113,13 +133,23 @@ func (enc *Enqueuer) Request() (*http.Request, error) {
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest(
-		"POST",
-		enc.RequestPath(),
-		bytes.NewReader(bodyBytes),
-	)
-	req.Header.Add("Content-Length", strconv.Itoa(len(bodyBytes)))
-	req.Header.Add("Content-Type", "application/json")
+	var req *http.Request
+	if enc.upload {
+		if req, err = enc.uploadRequest(bodyBytes); err != nil {
+			return nil, err
+		}
+	} else {
+		req, err = http.NewRequest(
+			"POST",
+			enc.RequestPath(),
+			bytes.NewReader(bodyBytes),
+		)
+		req.Header.Add("Content-Length", strconv.Itoa(len(bodyBytes)))
+		req.Header.Add("Content-Type", "application/json")
+	}
+	if enc.token != "" {
+		req.Header.Add("Authorization", "Bearer "+enc.token)
+	}
 	return req, nil
 }
 
@@ -130,9 +160,13 @@ func (enc *Enqueuer) Enqueue() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	reqBody, _ := ioutil.ReadAll(req.Body)
-	req.Body = ioutil.NopCloser(bytes.NewReader(reqBody)) // reset body after reading
-	Logger.Debugf("enqueueing request %s", reqBody)
+	if enc.upload {
+		Logger.Debugf("enqueueing upload of %s", enc.top)
+	} else {
+		reqBody, _ := ioutil.ReadAll(req.Body)
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody)) // reset body after reading
+		Logger.Debugf("enqueueing request %s", reqBody)
+	}
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
@@ -143,3 +177,43 @@ func (enc *Enqueuer) Enqueue() (string, error) {
 	contentBytes, _ := ioutil.ReadAll(resp.Body)
 	return string(contentBytes), nil
 }
+
+/*
+uploadRequest returns a multipart request containing the spec followed by a
+gzipped tar archive of the working directory (without .git) as the build
+context.  The archive is streamed as the request is sent.
+*/
+func (enc *Enqueuer) uploadRequest(specBytes []byte) (*http.Request, error) {
+	context, err := archive.TarWithOptions(enc.top, &archive.TarOptions{
+		Compression:     archive.Gzip,
+		ExcludePatterns: []string{".git"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bodyReader, bodyWriter := io.Pipe()
+	form := multipart.NewWriter(bodyWriter)
+
+	go func() {
+		defer context.Close()
+
+		err := form.WriteField("spec", string(specBytes))
+		if err == nil {
+			var part io.Writer
+			if part, err = form.CreateFormFile("context", "context.tar.gz"); err == nil {
+				if _, err = io.Copy(part, context); err == nil {
+					err = form.Close()
+				}
+			}
+		}
+		bodyWriter.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequest("POST", enc.RequestPath(), bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", form.FormDataContentType())
+	return req, nil
+}
@@ -2,8 +2,10 @@ package main_test
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/go-martini/martini"
@@ -27,6 +29,7 @@ func testServer() (testServer *martini.ClassicMartini) {
 	m.Action(r.Handle)
 	testServer = &martini.ClassicMartini{m, r}
 	testServer.Post(server.BuildRoute, webhook.DockerBuild)
+	testServer.Post(server.JobRoute, webhook.DockerBuild)
 	return
 }
 
@@ -77,3 +80,39 @@ func TestEnqueueRequestPath(t *testing.T) {
 		t.Errorf("expected request host %q, got %q", enqueuerHost+"/docker-build", enqueuer.RequestPath())
 	}
 }
+
+func TestEnqueueUploadRequest(t *testing.T) {
+	var testServer = testServer()
+	var recorder = httptest.NewRecorder()
+
+	top, err := ioutil.TempDir("", "enqueue-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(top)
+	ioutil.WriteFile(top+"/Bobfile", []byte("[docker]\n"), 0644)
+
+	var enqueuer = NewEnqueuer(EnqueueOptions{
+		Bobfile: "Bobfile",
+		Host:    enqueuerHost,
+		Top:     top,
+		Upload:  true,
+	})
+
+	if enqueuer.RequestPath() != enqueuerHost+"/jobs" {
+		t.Errorf("expected request path %q, got %q", enqueuerHost+"/jobs", enqueuer.RequestPath())
+	}
+
+	req, err := enqueuer.Request()
+	if err != nil {
+		t.Errorf("error making request: %q", err.Error())
+	}
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+		t.Errorf("expected a multipart request, got %q", req.Header.Get("Content-Type"))
+	}
+
+	testServer.ServeHTTP(recorder, req)
+	if recorder.Code != webhook.AsyncSuccessCode {
+		t.Errorf("expected response code %d, got %d", webhook.AsyncSuccessCode, recorder.Code)
+	}
+}
@@ -12,15 +12,15 @@ require (
 	github.com/codegangsta/inject v0.0.0-20150114235600-33e0aa1cb7c0 // indirect
 	github.com/docker/docker v1.4.2-0.20170724225022-92b3dcb60138
 	github.com/docker/go-connections v0.3.0 // indirect
-	github.com/docker/go-units v0.3.2 // indirect
-	github.com/fsouza/go-dockerclient v0.0.0-20170725183713-e991fbef2be0 // indirect
+	github.com/docker/go-units v0.3.2
+	github.com/fsouza/go-dockerclient v0.0.0-20170725183713-e991fbef2be0
 	github.com/go-martini/martini v0.0.0-20151114142712-15a47622d6a9
 	github.com/gogo/protobuf v0.0.0-20170720144805-7b6c6391c4ff // indirect
 	github.com/golang/protobuf v1.3.5 // indirect
 	github.com/hashicorp/go-cleanhttp v0.0.0-20170211013415-3573b8b52aa7 // indirect
 	github.com/kelseyhightower/envconfig v1.3.1-0.20170523190722-70f0258d44cb
 	github.com/martini-contrib/auth v0.0.0-20150219114609-fa62c19b7ae8
-	github.com/moby/moby v1.4.2-0.20170724225022-92b3dcb60138 // indirect
+	github.com/moby/moby v1.4.2-0.20170724225022-92b3dcb60138
 	github.com/modcloth/go-fileutils v0.0.0-20141210061911-f2bf9a2a6853
 	github.com/modcloth/kamino v0.1.3-0.20141121051143-10e9b9ebe46e
 	github.com/nu7hatch/gouuid v0.0.0-20131221200532-179d4d0c4d8d
@@ -30,13 +30,14 @@ require (
 	github.com/opencontainers/go-digest v1.0.0-rc1 // indirect
 	github.com/opencontainers/image-spec v1.0.1-0.20170720143143-ef2b9a1d6966 // indirect
 	github.com/opencontainers/runc v1.0.0-rc3.0.20170725134754-5951cf5f36e1 // indirect
-	github.com/rafecolton/go-dockerclient-quick v0.0.0-20141218223604-ebab26ac4bc4 // indirect
+	github.com/rafecolton/go-dockerclient-quick v0.0.0-20141218223604-ebab26ac4bc4
 	github.com/rafecolton/go-dockerclient-sort v0.0.0-20141111135947-127186d3d0bd // indirect
 	github.com/rafecolton/go-gitutils v0.0.0-20141203024321-981699062113
 	github.com/rafecolton/vauth v0.1.2
 	github.com/stretchr/testify v1.5.1 // indirect
 	github.com/winchman/builder-core v0.2.3-0.20170726143510-79bf5d150337
 	golang.org/x/lint v0.0.0-20200302205851-738671d3881b // indirect
+	golang.org/x/net v0.0.0-20200226121028-0de0cce0169b
 	golang.org/x/tools v0.0.0-20200329025819-fd4102a86c65 // indirect
-	gopkg.in/yaml.v2 v2.2.8 // indirect
+	gopkg.in/yaml.v2 v2.2.8
 )
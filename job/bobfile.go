@@ -0,0 +1,122 @@
+package job
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/winchman/builder-core/filecheck"
+	"gopkg.in/yaml.v2"
+)
+
+/*
+bobfileOptions are the options in a Bobfile that are used by the server rather
+than by builder-core, which ignores them, e.g.
+
+	[git]
+	submodules = true
+
+	[container_globals]
+	push_attempts = 3
+
+	[[container]]
+	name = "app"
+	push_backoff = "5s"
+*/
+type bobfileOptions struct {
+	Git              gitOptions         `toml:"git" json:"git" yaml:"git"`
+	ContainerGlobals *containerOptions  `toml:"container_globals" json:"container_globals" yaml:"container_globals"`
+	Containers       []containerOptions `toml:"container" json:"container" yaml:"container"`
+}
+
+type gitOptions struct {
+	Submodules bool `toml:"submodules" json:"submodules" yaml:"submodules"`
+}
+
+type containerOptions struct {
+	Name         string `toml:"name" json:"name" yaml:"name"`
+	PushAttempts int    `toml:"push_attempts" json:"push_attempts" yaml:"push_attempts"`
+	PushBackoff  string `toml:"push_backoff" json:"push_backoff" yaml:"push_backoff"`
+}
+
+/*
+pushRetry returns the retry policy for pushes from the named container section.
+Settings in the section take precedence over those in the container globals,
+which take precedence over the server's policy.
+*/
+func (opts *bobfileOptions) pushRetry(section string, server RetryPolicy) (RetryPolicy, error) {
+	ret := server
+
+	apply := func(c *containerOptions) error {
+		if c.PushAttempts > 0 {
+			ret.Attempts = c.PushAttempts
+		}
+		if c.PushBackoff != "" {
+			backoff, err := time.ParseDuration(c.PushBackoff)
+			if err != nil || backoff <= 0 {
+				return fmt.Errorf("push_backoff %q is not a positive duration", c.PushBackoff)
+			}
+			ret.Backoff = backoff
+		}
+		return nil
+	}
+
+	if opts.ContainerGlobals != nil {
+		if err := apply(opts.ContainerGlobals); err != nil {
+			return ret, err
+		}
+	}
+	for i := range opts.Containers {
+		if opts.Containers[i].Name == section {
+			if err := apply(&opts.Containers[i]); err != nil {
+				return ret, err
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+/*
+readBobfileOptions reads the server's options from the Bobfile at path, which
+(like builder-core does) may be encoded as TOML, JSON or YAML.
+*/
+func readBobfileOptions(path string) (*bobfileOptions, error) {
+	file, err := filecheck.NewTrustedFilePath(filecheck.NewTrustedFilePathOptions{
+		File: filepath.Base(path),
+		Top:  filepath.Dir(path),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if file.Sanitize(); file.State != filecheck.OK {
+		return nil, errors.New("provided file is unchecked or unsanitary")
+	}
+
+	contents, err := ioutil.ReadFile(file.FullPath())
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &bobfileOptions{}
+	if _, err = toml.Decode(string(contents), ret); err == nil {
+		return ret, nil
+	}
+
+	ret = &bobfileOptions{}
+	if err = json.NewDecoder(bytes.NewReader(contents)).Decode(ret); err == nil {
+		return ret, nil
+	}
+
+	ret = &bobfileOptions{}
+	if err = yaml.Unmarshal(contents, ret); err == nil {
+		return ret, nil
+	}
+
+	return nil, err
+}
@@ -0,0 +1,228 @@
+package job
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/modcloth/go-fileutils"
+	gouuid "github.com/nu7hatch/gouuid"
+)
+
+/*
+authFailedRegex matches what git clone prints when the remote refuses (or
+needs) credentials, which won't change by trying again.  GitHub says a private
+repo isn't found rather than asking for credentials.
+*/
+var authFailedRegex = regexp.MustCompile(`Authentication failed|could not read (Username|Password)|` +
+	`Permission denied \(publickey|Host key verification failed|returned error: 40[13]|Repository not found`)
+
+func (job *Job) clone() (string, error) {
+	_, option := cloneCache()
+	fields := logrus.Fields{
+		"api_token_present":  job.GitHubAPIToken != "",
+		"account":            job.Account,
+		"ref":                job.Ref,
+		"repo":               job.Repo,
+		"clone_url":          job.CloneURL,
+		"clone_depth":        job.GitCloneDepth,
+		"clone_cache_option": option,
+	}
+
+	job.Logger.WithFields(fields).Info("starting clone process")
+
+	remote, err := job.remote()
+	if err != nil {
+		job.Logger.WithFields(fields).WithField("error", err).Error("invalid clone url")
+		return "", err
+	}
+
+	path, cached, err := job.cloneCached(remote)
+	if err != nil {
+		job.Logger.WithFields(fields).WithField("error", err).Error("issue using clone cache")
+		return "", err
+	}
+	if cached {
+		job.Logger.WithFields(fields).WithField("path", path).Info("using cached clone")
+	} else {
+		uuid, err := gouuid.NewV4()
+		if err != nil {
+			return "", err
+		}
+		path = fmt.Sprintf("%s/%s/%s", job.Workdir, job.Account, uuid)
+
+		job.Logger.Debug("attempting to clone")
+
+		if err := job.retryClone(remote, path); err != nil {
+			fileutils.RmRF(path)
+			job.Logger.WithFields(fields).WithField("error", err).Error("issue cloning")
+			return "", err
+		}
+
+		job.Logger.WithFields(fields).Info("cloning successful")
+	}
+
+	if err := job.checkoutSubmodules(remote, path); err != nil {
+		job.releaseClone(path)
+		job.Logger.WithFields(fields).WithField("error", err).Error("issue checking out submodules")
+		return "", err
+	}
+
+	if err := job.resolveCommit(remote, path); err != nil {
+		job.releaseClone(path)
+		job.Logger.WithFields(fields).WithField("error", err).Error("issue resolving ref")
+		return "", err
+	}
+
+	return path, nil
+}
+
+/*
+resolveCommit records the SHA of the commit checked out at path, which is what
+the job's ref (e.g. a tag or branch name) pointed to when it was cloned
+*/
+func (job *Job) resolveCommit(remote *remote, path string) error {
+	git, err := fileutils.Which("git")
+	if err != nil {
+		return err
+	}
+
+	out, err := job.gitOutput(remote, git, path, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+
+	commit := strings.TrimSpace(out)
+	job.Logger.WithField("commit", commit).Info("resolved ref")
+	job.update(func() { job.Commit = commit })
+
+	return nil
+}
+
+/*
+gitClone clones the repo from remote into dest, only fetching the job's clone
+depth worth of history if it has one.  git is killed if the job is cancelled.
+*/
+func (job *Job) gitClone(remote *remote, dest string) error {
+	git, err := fileutils.Which("git")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+
+	args := []string{"clone"}
+	if job.GitCloneDepth != "" {
+		// --depth implies --single-branch, which would leave the job's ref
+		// out if it isn't on the default branch
+		args = append(args, "--depth", job.GitCloneDepth, "--no-single-branch")
+	}
+	args = append(args, "--", remote.url, dest)
+
+	return job.runGit(remote, git, "", args...)
+}
+
+// gitCheckout checks out the job's ref in the clone at dest (the same way
+// kamino does)
+func (job *Job) gitCheckout(remote *remote, dest string) error {
+	git, err := fileutils.Which("git")
+	if err != nil {
+		return err
+	}
+
+	return job.runGit(remote, git, dest, "checkout", "--force", job.Ref)
+}
+
+/*
+retryClone clones the repo with gitClone, starting over according to the
+retry policy if it fails (e.g. because the remote can't be reached), and then
+checks out the job's ref.  Failures that another attempt won't fix aren't
+retried: the remote refusing the credentials, or the ref not being there.
+*/
+func (job *Job) retryClone(remote *remote, dest string) error {
+	policy := currentRetryPolicy()
+
+	failed := func(attempt int, err error, wait time.Duration) {
+		fileutils.RmRF(dest)
+		job.Logger.WithFields(logrus.Fields{
+			"attempt":  attempt,
+			"attempts": policy.Attempts,
+			"error":    err,
+			"retry_in": wait,
+		}).Warn("clone failed, retrying")
+		job.recordEvent(newRetryEvent("clone", attempt, policy.Attempts, err, wait))
+	}
+
+	err := retry(job.ctx, policy, failed, func() error {
+		err := job.gitClone(remote, dest)
+		if gitErr, ok := err.(*gitError); ok && authFailedRegex.MatchString(gitErr.output) {
+			return &permanentError{err: err}
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return job.gitCheckout(remote, dest)
+}
+
+func (job *Job) runGit(remote *remote, git, dir string, args ...string) error {
+	_, err := job.gitOutput(remote, git, dir, args...)
+	return err
+}
+
+// gitOutput runs a git command the same way as runGit, returning its output
+func (job *Job) gitOutput(remote *remote, git, dir string, args ...string) (string, error) {
+	buff := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	cmd := exec.CommandContext(job.ctx, git, args...)
+	cmd.Dir = dir
+	cmd.Env = remote.environ()
+	cmd.Stdout = buff
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctxErr := job.ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
+
+		job.Logger.WithFields(logrus.Fields{
+			"command": "git " + args[0],
+			"error":   err,
+			"output":  remote.redact(buff.String() + stderr.String()),
+		}).Error("error running git command")
+
+		return "", &gitError{command: args[0], err: err, output: remote.redact(stderr.String())}
+	}
+
+	return buff.String(), nil
+}
+
+// gitError is the error of a git command that failed, with what it printed to
+// stderr (redacted) so that the reason can be told apart
+type gitError struct {
+	command string
+	err     error
+	output  string
+}
+
+func (e *gitError) Error() string {
+	return fmt.Sprintf("git %s failed: %s", e.command, e.err)
+}
+
+// runGitQuiet runs a git command that is expected to fail at times (e.g. to
+// check whether a ref exists), so its output isn't logged
+func (job *Job) runGitQuiet(git, dir string, args ...string) error {
+	cmd := exec.CommandContext(job.ctx, git, args...)
+	cmd.Dir = dir
+	return cmd.Run()
+}
@@ -0,0 +1,210 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/modcloth/go-fileutils"
+	"github.com/modcloth/kamino"
+)
+
+var (
+	cloneCacheDir    string
+	cloneCacheOption = kamino.No
+	cloneCacheLock   sync.RWMutex
+
+	// one lock per cached checkout, so that only one job at a time uses it
+	cacheLocks     = map[string]chan struct{}{}
+	cacheLocksLock sync.Mutex
+)
+
+/*
+SetCloneCache configures the (global) clone cache.  Repos are cached in dir,
+one checkout per remote, and option decides how the cache is used, the same
+way as kamino's cache options:
+
+  - kamino.No - always make a fresh clone (the default)
+  - kamino.Create - use the cached checkout, cloning it first if it isn't there
+  - kamino.IfAvailable - use the cached checkout if it's there, otherwise
+    make a fresh clone without caching it
+  - kamino.Force - use the cached checkout, failing if it isn't there
+*/
+func SetCloneCache(dir string, option kamino.CacheOption) error {
+	if option == "" {
+		option = kamino.No
+	}
+	if !option.IsValid() {
+		return fmt.Errorf("%q is not a valid clone cache option", option)
+	}
+	if option != kamino.No && dir == "" {
+		return fmt.Errorf("a clone cache dir is needed for the %q clone cache option", option)
+	}
+
+	cloneCacheLock.Lock()
+	defer cloneCacheLock.Unlock()
+
+	cloneCacheDir = dir
+	cloneCacheOption = option
+	return nil
+}
+
+func cloneCache() (string, kamino.CacheOption) {
+	cloneCacheLock.RLock()
+	defer cloneCacheLock.RUnlock()
+
+	return cloneCacheDir, cloneCacheOption
+}
+
+/*
+cachePath is where the checkout of the remote at host is cached, which has to
+be inside dir since whatever is there may be removed.
+*/
+func cachePath(dir, host, account, repo string) (string, error) {
+	if host == "" {
+		host = "local"
+	}
+	path := filepath.Join(dir, host, account, repo)
+	if rel, err := filepath.Rel(dir, path); err != nil || rel == "." || rel == ".." ||
+		strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s/%s from %q can't be cached", account, repo, host)
+	}
+	return path, nil
+}
+
+/*
+lockCache waits until no other job is using the cached checkout at path (or
+until ctx is done) and returns the func that releases it again.
+*/
+func lockCache(ctx context.Context, path string) (func(), error) {
+	cacheLocksLock.Lock()
+	lock, ok := cacheLocks[path]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		cacheLocks[path] = lock
+	}
+	cacheLocksLock.Unlock()
+
+	select {
+	case lock <- struct{}{}:
+		return func() { <-lock }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+/*
+cloneCached brings the cached checkout of the job's repo up to date, cloning it
+first if the cache option allows.  cached is false if the checkout isn't (and
+won't be) cached, in which case the caller should make a fresh clone instead.
+While a cached checkout is in use it is locked, and job.releaseCache must be
+called once the job is done with it.
+*/
+func (job *Job) cloneCached(remote *remote) (path string, cached bool, err error) {
+	dir, option := cloneCache()
+	if option == kamino.No {
+		return "", false, nil
+	}
+
+	path, err = cachePath(dir, remote.host, job.Account, job.Repo)
+	if err != nil {
+		return "", false, err
+	}
+
+	release, err := lockCache(job.ctx, path)
+	if err != nil {
+		return "", false, err
+	}
+
+	_, statErr := os.Stat(path + "/.git")
+	exists := statErr == nil
+
+	if exists {
+		if err = job.gitUpdate(remote, path); err == nil {
+			job.releaseCache = release
+			return path, true, nil
+		}
+		if job.ctx.Err() != nil {
+			release()
+			return "", false, err
+		}
+		job.Logger.WithField("error", err).Warn("unable to update cached checkout")
+	}
+
+	switch option {
+	case kamino.Create:
+		// start over with a fresh checkout in the cache
+		fileutils.RmRF(path)
+		if err = job.retryClone(remote, path); err != nil {
+			fileutils.RmRF(path)
+			release()
+			return "", false, err
+		}
+		job.releaseCache = release
+		return path, true, nil
+	case kamino.Force:
+		release()
+		if err == nil {
+			err = fmt.Errorf("no cached checkout of %s/%s", job.Account, job.Repo)
+		}
+		return "", false, err
+	}
+
+	// kamino.IfAvailable
+	release()
+	return "", false, nil
+}
+
+/*
+gitUpdate brings an existing checkout at dest up to date with the remote and
+checks out the job's ref, removing anything left behind by previous builds.
+*/
+func (job *Job) gitUpdate(remote *remote, dest string) error {
+	git, err := fileutils.Which("git")
+	if err != nil {
+		return err
+	}
+
+	// the remote's credentials may have changed since the checkout was cached
+	if err := job.runGit(remote, git, dest, "remote", "set-url", "--", "origin", remote.url); err != nil {
+		return err
+	}
+
+	fetch := []string{"fetch", "--prune", "--tags", "--force"}
+	if job.GitCloneDepth != "" {
+		fetch = append(fetch, "--depth", job.GitCloneDepth)
+	}
+	fetch = append(fetch, "origin", "+refs/heads/*:refs/remotes/origin/*")
+
+	for _, args := range [][]string{
+		{"reset", "--hard"},
+		// the second --force removes leftover submodules too
+		{"clean", "-d", "-x", "--force", "--force"},
+		fetch,
+	} {
+		if err := job.runGit(remote, git, dest, args...); err != nil {
+			return err
+		}
+	}
+
+	// a branch is checked out at the commit just fetched for it rather than
+	// wherever it was left the last time it was built
+	if job.runGitQuiet(git, dest, "rev-parse", "--verify", "--quiet", "refs/remotes/origin/"+job.Ref) == nil {
+		return job.runGit(remote, git, dest, "checkout", "--force", "-B", job.Ref, "refs/remotes/origin/"+job.Ref)
+	}
+
+	return job.runGit(remote, git, dest, "checkout", "--force", job.Ref)
+}
+
+// releaseClone cleans up after the job is done with its cloned repo at path
+func (job *Job) releaseClone(path string) {
+	if job.releaseCache != nil {
+		job.releaseCache()
+		job.releaseCache = nil
+		return
+	}
+	fileutils.RmRF(path)
+}
@@ -0,0 +1,198 @@
+package job
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// DefaultGitHubAPIURL is the base URL of the public GitHub API
+const DefaultGitHubAPIURL = "https://api.github.com"
+
+// commitStatusContext identifies docker-builder's statuses among a commit's others
+const commitStatusContext = "docker-builder"
+
+var (
+	// GitHubAPIURL is the base URL of the GitHub API used for reporting
+	// commit statuses (set it to use GitHub Enterprise)
+	GitHubAPIURL = DefaultGitHubAPIURL
+
+	commitStatuses     chan *commitStatus
+	commitStatusesOnce sync.Once
+	commitStatusClient = &http.Client{Timeout: 30 * time.Second}
+)
+
+type commitStatus struct {
+	url   string
+	token string
+	body  []byte
+	log   logrus.Fields
+}
+
+type commitStatusBody struct {
+	State       string `json:"state"`
+	TargetURL   string `json:"target_url,omitempty"`
+	Description string `json:"description,omitempty"`
+	Context     string `json:"context"`
+}
+
+// shaRegex matches a full commit SHA
+var shaRegex = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// reportsCommitStatus indicates whether or not the job's status is reported
+// to GitHub.  Only jobs triggered by a GitHub or Travis webhook are, since
+// those are the ones whose repo is known to be on GitHub.
+func (job *Job) reportsCommitStatus() bool {
+	return (job.Trigger == TriggerGitHub || job.Trigger == TriggerTravis) && job.GitHubAPIToken != ""
+}
+
+/*
+statusCommit is the SHA of the commit the job's statuses are reported on: the
+commit its ref resolved to when it was cloned, or the ref itself if that's
+already a SHA.  It's empty for a ref such as a tag name until the repo has
+been cloned.
+*/
+func (job *Job) statusCommit() string {
+	if job.Commit != "" {
+		return job.Commit
+	}
+	if shaRegex.MatchString(job.Ref) {
+		return job.Ref
+	}
+	return ""
+}
+
+/*
+reportCommitStatus sends the job's status to the GitHub Statuses API for the
+commit being built (see statusCommit): "pending" while the job is in progress, then "success",
+"failure" (if the job errored) or "error" (if the job didn't finish building).
+Statuses are sent in the background, in the order they are reported.
+*/
+func reportCommitStatus(job *Job) {
+	snapshot := job.Snapshot()
+	if !snapshot.reportsCommitStatus() {
+		return
+	}
+
+	sha := snapshot.statusCommit()
+	if sha == "" {
+		return
+	}
+
+	state, description := commitState(snapshot)
+	if state == "" {
+		return
+	}
+
+	body, err := json.Marshal(&commitStatusBody{
+		State:       state,
+		TargetURL:   snapshot.InfoRoute,
+		Description: description,
+		Context:     commitStatusContext,
+	})
+	if err != nil {
+		return
+	}
+
+	commitStatusesOnce.Do(func() {
+		commitStatuses = make(chan *commitStatus, 100)
+		go sendCommitStatuses(commitStatuses)
+	})
+
+	status := &commitStatus{
+		url: fmt.Sprintf("%s/repos/%s/%s/statuses/%s",
+			strings.TrimSuffix(GitHubAPIURL, "/"), snapshot.Account, snapshot.Repo, sha),
+		token: snapshot.GitHubAPIToken,
+		body:  body,
+		log: logrus.Fields{
+			"job_id": snapshot.ID,
+			"state":  state,
+		},
+	}
+
+	select {
+	case commitStatuses <- status:
+	default:
+		logCommitStatusError(status, "too many pending commit statuses, dropping commit status")
+	}
+}
+
+func commitState(job *Job) (state, description string) {
+	switch job.Status {
+	// a job that goes from created to queued is still waiting to start, so
+	// there's nothing new to report for the latter
+	case StatusCreated:
+		return "pending", "build is waiting to start"
+	case StatusCloning:
+		return "pending", "cloning repo"
+	case StatusBuilding:
+		return "pending", "building images"
+	case StatusCompleted:
+		return "success", "build completed"
+	case StatusErrored:
+		description = "build errored"
+		if job.Error != "" {
+			description += ": " + job.Error
+		}
+		// GitHub rejects descriptions longer than 140 characters
+		if len(description) > 140 {
+			description = description[:137] + "..."
+		}
+		return "failure", description
+	case StatusCancelled:
+		return "error", "build was cancelled"
+	case StatusTimedOut:
+		return "error", "build timed out"
+	case StatusSuperseded:
+		return "error", "build was superseded by a newer commit"
+	}
+	return "", ""
+}
+
+func sendCommitStatuses(statuses chan *commitStatus) {
+	for status := range statuses {
+		if err := status.send(); err != nil {
+			status.log["error"] = err
+			logCommitStatusError(status, "unable to report commit status")
+		}
+	}
+}
+
+func (status *commitStatus) send() error {
+	req, err := http.NewRequest("POST", status.url, bytes.NewReader(status.body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("Authorization", "token "+status.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := commitStatusClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+
+	return nil
+}
+
+func logCommitStatusError(status *commitStatus, message string) {
+	if logger != nil {
+		logger.WithFields(status.log).Error(message)
+	}
+}
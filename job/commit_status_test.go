@@ -0,0 +1,156 @@
+package job_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rafecolton/docker-builder/job"
+)
+
+var _ = Describe("GitHub commit statuses", func() {
+	const sha = "0123456789abcdef0123456789abcdef01234567"
+
+	type status struct {
+		Path          string
+		Authorization string
+		State         string `json:"state"`
+		TargetURL     string `json:"target_url"`
+		Context       string `json:"context"`
+	}
+
+	var (
+		github   *httptest.Server
+		lock     sync.Mutex
+		statuses []status
+		workdir  string
+		config   *Config
+	)
+
+	received := func() []status {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]status{}, statuses...)
+	}
+
+	newJob := func(trigger string) *Job {
+		req, _ := makeRequest("POST", "docker-build/github", nil)
+		return NewJob(config, &Spec{
+			RepoOwner:      "foo",
+			RepoName:       "bar",
+			GitRef:         sha,
+			GitHubAPIToken: "t0ken",
+			Trigger:        trigger,
+		}, req)
+	}
+
+	BeforeEach(func() {
+		statuses = []status{}
+		github = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			var s status
+			json.NewDecoder(req.Body).Decode(&s)
+			s.Path = req.URL.Path
+			s.Authorization = req.Header.Get("Authorization")
+			statuses = append(statuses, s)
+			w.WriteHeader(201)
+		}))
+		GitHubAPIURL = github.URL
+
+		workdir, _ = ioutil.TempDir("", "commit-status")
+		config = &Config{
+			Workdir: workdir,
+			Logger:  &logrus.Logger{Out: ioutil.Discard, Formatter: &logrus.JSONFormatter{}, Level: logrus.PanicLevel},
+		}
+	})
+
+	AfterEach(func() {
+		GitHubAPIURL = DefaultGitHubAPIURL
+		github.Close()
+		os.RemoveAll(workdir)
+	})
+
+	It("reports pending and then success for jobs triggered by GitHub", func() {
+		j := newJob(TriggerGitHub)
+		Expect(j.Process()).To(BeNil())
+
+		Eventually(func() int { return len(received()) }).Should(Equal(2))
+		for i, state := range []string{"pending", "success"} {
+			s := received()[i]
+			Expect(s.Path).To(Equal("/repos/foo/bar/statuses/" + sha))
+			Expect(s.Authorization).To(Equal("token t0ken"))
+			Expect(s.State).To(Equal(state))
+			Expect(s.TargetURL).To(Equal(j.Snapshot().InfoRoute))
+			Expect(s.Context).To(Equal("docker-builder"))
+		}
+	})
+
+	It("does not report statuses for jobs created through the API", func() {
+		Expect(newJob(TriggerAPI).Process()).To(BeNil())
+		newJob(TriggerTravis)
+
+		Eventually(func() int { return len(received()) }).Should(Equal(1))
+		Consistently(func() int { return len(received()) }).Should(Equal(1))
+	})
+
+	It("reports statuses for a tag on the commit the tag points to", func() {
+		git := func(dir string, args ...string) string {
+			cmd := exec.Command("git", args...)
+			cmd.Dir = dir
+			cmd.Env = append(os.Environ(),
+				"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+				"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+			)
+			out, err := cmd.CombinedOutput()
+			Expect(err).To(BeNil(), string(out))
+			return strings.TrimSpace(string(out))
+		}
+
+		// a Bobfile without any containers builds without Docker
+		src := workdir + "/src"
+		os.MkdirAll(src, 0755)
+		git(src, "init", "-q", "-b", "master")
+		ioutil.WriteFile(src+"/Bobfile", []byte("[docker]\n"), 0644)
+		git(src, "add", "Bobfile")
+		git(src, "commit", "-q", "-m", "release")
+		git(src, "tag", "v1.0.0")
+		tagged := git(src, "rev-parse", "HEAD")
+		git(workdir, "clone", "-q", "--bare", src, workdir+"/bare.git")
+
+		req, _ := makeRequest("POST", "docker-build/github", nil)
+		j := NewJob(config, &Spec{
+			RepoOwner:      "foo",
+			RepoName:       "bar",
+			GitRef:         "v1.0.0",
+			CloneURL:       "file://" + workdir + "/bare.git",
+			GitHubAPIToken: "t0ken",
+			Trigger:        TriggerGitHub,
+		}, req)
+
+		TestMode = false
+		err := j.Process()
+		TestMode = true
+		Expect(err).To(BeNil())
+		Expect(j.Snapshot().Commit).To(Equal(tagged))
+
+		Eventually(func() []string {
+			states := []string{}
+			for _, s := range received() {
+				Expect(s.Path).To(Equal("/repos/foo/bar/statuses/" + tagged))
+				states = append(states, s.State)
+			}
+			return states
+		}).Should(Equal([]string{"pending", "success"}))
+	})
+})
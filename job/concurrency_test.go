@@ -0,0 +1,92 @@
+package job_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rafecolton/docker-builder/job"
+)
+
+// these specs are most useful when run with the race detector (`make race`)
+var _ = Describe("concurrent access to jobs", func() {
+	const rounds = 25
+
+	serve := func(method, path string, body []byte) int {
+		req, _ := makeRequest(method, path, body)
+		rec := httptest.NewRecorder()
+		testServer.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	It("allows jobs to be created, listed and tailed at the same time", func() {
+		var wg sync.WaitGroup
+
+		for i := 0; i < rounds; i++ {
+			wg.Add(4)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				Expect(serve("POST", "jobs", data)).To(Equal(201))
+			}()
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				Expect(serve("GET", "jobs", nil)).To(Equal(200))
+			}()
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				Expect(serve("GET", "jobs/"+jobID, nil)).To(Or(Equal(200), Equal(404)))
+			}()
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				Expect(serve("GET", "jobs/"+jobID+"/tail?n=1", nil)).To(Or(Equal(200), Equal(404), Equal(412)))
+			}()
+		}
+
+		wg.Wait()
+	})
+
+	It("allows jobs to be serialized while they are being processed", func() {
+		var wg sync.WaitGroup
+		var q = NewQueue()
+		var jobs = []*Job{}
+
+		for i := 0; i < rounds; i++ {
+			j := &Job{ID: fmt.Sprintf("concurrent-%d", i), Logger: &logrus.Logger{
+				Out:       ioutil.Discard,
+				Formatter: &logrus.JSONFormatter{},
+				Level:     logrus.PanicLevel,
+			}}
+			jobs = append(jobs, j)
+			q.Push(j)
+		}
+
+		q.Start(4)
+
+		for _, j := range jobs {
+			wg.Add(1)
+			go func(j *Job) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				for k := 0; k < 10; k++ {
+					_, err := json.Marshal(j.Snapshot())
+					Expect(err).To(BeNil())
+				}
+			}(j)
+		}
+
+		wg.Wait()
+		for _, j := range jobs {
+			Eventually(func() string { return j.Snapshot().Status }).Should(Equal(StatusCompleted))
+		}
+	})
+})
@@ -5,16 +5,15 @@ import (
 	"encoding/json"
 	"net/http"
 	"os"
-	"reflect"
-	"sort"
 	"strconv"
+	"strings"
+
+	"github.com/rafecolton/docker-builder/tokens"
 
 	"github.com/docker/docker/pkg/tailfile"
 	"github.com/go-martini/martini"
 )
 
-var jobs = map[string]*Job{}
-
 /*
 TailN is the handler function for the job log tailing route.
 */
@@ -31,7 +30,12 @@ func TailN(params martini.Params, req *http.Request) (int, string) {
 		return 400, n + " is not a valid number"
 	}
 
-	out, err := tailN(intN, id)
+	job := store.Get(id)
+	if job == nil {
+		return 404, "404 not found"
+	}
+
+	out, err := tailN(intN, job)
 	if err != nil {
 		return 412, err.Error()
 	}
@@ -39,8 +43,7 @@ func TailN(params martini.Params, req *http.Request) (int, string) {
 	return 200, out
 }
 
-func tailN(n int, id string) (string, error) {
-	job := jobs[id]
+func tailN(n int, job *Job) (string, error) {
 	logFilePath := job.logDir + "/log.log"
 
 	file, err := os.Open(logFilePath)
@@ -58,12 +61,38 @@ func tailN(n int, id string) (string, error) {
 	return string(out) + "\n", err
 }
 
+/*
+CheckAccess is a handler for the routes of a single job that responds with a
+404 if the request's API token is limited to repos that don't include the
+job's, as if the job didn't exist.
+*/
+func CheckAccess(params martini.Params, w http.ResponseWriter, req *http.Request) {
+	token := tokens.FromRequest(req)
+	if token == nil {
+		return
+	}
+
+	job := store.Get(params["id"])
+	if job == nil {
+		return
+	}
+
+	snapshot := job.Snapshot()
+	if !token.Allows(snapshot.Account, snapshot.Repo) {
+		w.WriteHeader(404)
+		w.Write([]byte(`{"error": "job not found"}`))
+	}
+}
+
 //Get gets the requested job as JSON.
 func Get(params martini.Params, req *http.Request) (int, string) {
 	id := params["id"]
-	job := jobs[id]
+	job := store.Get(id)
+	if job == nil {
+		return 404, `{"error": "job not found"}`
+	}
 
-	retBytes, err := json.Marshal(job)
+	retBytes, err := json.Marshal(job.Snapshot())
 	if err != nil {
 		return 409, `{"error": "` + err.Error() + `"}`
 	}
@@ -71,61 +100,63 @@ func Get(params martini.Params, req *http.Request) (int, string) {
 	return 200, string(retBytes)
 }
 
-//GetAll gets all of the jobs as JSON.
-func GetAll(params martini.Params, req *http.Request) (int, string) {
-	var jobArr = []*Job{}
-
-	for _, job := range jobs {
-		var matches = true
+/*
+Cancel is the handler function for the job cancellation route.  It responds
+with the job as JSON once cancellation has been requested.
+*/
+func Cancel(params martini.Params, req *http.Request) (int, string) {
+	id := params["id"]
+	job := store.Get(id)
+	if job == nil {
+		return 404, `{"error": "job not found"}`
+	}
 
-		for attr, value := range req.URL.Query() {
-			// `desiredValue` is the string the user wishes the field to match.
-			// If multiple values are provided, the first will be used and the
-			// rest will be ignored
-			var desiredValue = value[0]
+	if err := job.Cancel(); err != nil {
+		return 409, `{"error": "` + err.Error() + `"}`
+	}
 
-			// if the user provided an invalid attribute by which to filter, it
-			// is ignored
-			if parameterFieldMapping[attr] == "" {
-				continue
-			}
+	retBytes, err := json.Marshal(job.Snapshot())
+	if err != nil {
+		return 409, `{"error": "` + err.Error() + `"}`
+	}
 
-			// determine the value of the job struct for the requested attribute
-			structField := parameterFieldMapping[attr]
+	return 200, string(retBytes)
+}
 
-			strukt := reflect.ValueOf(job).Elem()
-			var actualValue = strukt.FieldByName(structField).String()
+/*
+GetAll is the handler function for listing jobs as JSON, filtered, sorted and
+paged according to the query string (see the docs).  The total number of jobs
+that matched is given in the X-Total-Count header, and the Link header has the
+pages before and after this one.
+*/
+func GetAll(w http.ResponseWriter, req *http.Request) (int, string) {
+	query, err := parseJobQuery(req.URL.Query())
+	if err != nil {
+		return 400, `{"error": "` + err.Error() + `"}`
+	}
 
-			// if it doesn't match, mark this one a dud and move on
-			if actualValue != desiredValue {
-				matches = false
-				break
-			}
-		}
+	// a token that's limited to certain repos only sees their jobs
+	token := tokens.FromRequest(req)
 
-		// if we reach this point and have not called it a dud yet (i.e. never
-		// marked `matches` as `false`), added it to the list we'll be returning
-		if matches {
-			jobArr = append(jobArr, job)
+	var jobs []*Job
+	for _, stored := range store.All() {
+		job := stored.Snapshot()
+		if token == nil || token.Allows(job.Account, job.Repo) {
+			jobs = append(jobs, job)
 		}
 	}
 
-	// sort the final (possibly shortened) list
-	sort.Sort(ByCreatedDescending(jobArr))
+	page, total := query.run(jobs)
 
-	retBytes, err := json.Marshal(jobArr)
+	retBytes, err := json.Marshal(page)
 	if err != nil {
 		return 409, `{"error": "` + err.Error() + `"}`
 	}
 
-	return 200, string(retBytes)
-}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if links := query.links(req.URL, total); len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
 
-var parameterFieldMapping = map[string]string{
-	"account": "Account",
-	"bobfile": "Bobfile",
-	"id":      "ID",
-	"ref":     "Ref",
-	"repo":    "Repo",
-	"status":  "Status",
+	return 200, string(retBytes)
 }
@@ -0,0 +1,60 @@
+package job
+
+import (
+	"time"
+)
+
+/*
+FindDuplicate returns a job for the same account, repo, commit and Bobfile as
+spec that hasn't finished yet, or nil if there is no such job (see
+sameCommit).  Jobs built from an uploaded build context are never duplicates,
+since their contexts may differ.
+*/
+func FindDuplicate(spec *Spec) *Job {
+	if spec.Upload != nil || spec.ContextDir != "" {
+		return nil
+	}
+
+	bobfile := spec.Bobfile
+	if bobfile == "" {
+		bobfile = defaultBobfile
+	}
+
+	var ret *Job
+	var created time.Time
+	for _, job := range store.All() {
+		if job.finished() {
+			continue
+		}
+
+		snapshot := job.Snapshot()
+		if snapshot.Uploaded ||
+			snapshot.Account != spec.RepoOwner ||
+			snapshot.Repo != spec.RepoName ||
+			!sameCommit(snapshot, spec.GitRef) ||
+			snapshot.Bobfile != bobfile {
+			continue
+		}
+
+		// the oldest one is the one furthest along
+		if ret == nil || snapshot.Created.Before(created) {
+			ret = job
+			created = snapshot.Created
+		}
+	}
+
+	return ret
+}
+
+/*
+sameCommit indicates whether or not building ref would build the same commit
+as job.  A SHA matches a job for that SHA or one whose ref resolved to it.  Any
+other ref (e.g. a branch) only matches a job for the same ref that hasn't been
+cloned yet, since the ref may have moved on since the job resolved it.
+*/
+func sameCommit(job *Job, ref string) bool {
+	if shaRegex.MatchString(ref) {
+		return job.Ref == ref || job.Commit == ref
+	}
+	return job.Ref == ref && job.Commit == ""
+}
@@ -0,0 +1,105 @@
+package job
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/rafecolton/go-dockerclient-quick"
+)
+
+// newDockerClient is swapped out by the specs, which don't have a Docker daemon
+var newDockerClient = dockerclient.NewDockerClient
+
+/*
+contextClient returns a copy of client whose connections to the Docker API are
+closed as soon as ctx is done, along with a func that releases the copy's idle
+connections once it's no longer needed.  It's for the builder-core commands
+(builds in particular) that don't take a context, so that a build that is stuck
+is stopped when the job times out or is cancelled rather than holding up the
+job until the daemon gives up on it.
+*/
+func contextClient(ctx context.Context, client dockerclient.DockerClient) (dockerclient.DockerClient, func()) {
+	original := client.Client()
+	if original == nil || original.HTTPClient == nil {
+		// the fake client, which builder-core commands don't call
+		return client, func() {}
+	}
+
+	copied := *original
+	dialer := copied.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	copied.Dialer = &contextDialer{ctx: ctx, dialer: dialer}
+
+	release := func() {}
+	if transport, ok := original.HTTPClient.Transport.(*http.Transport); ok {
+		transport = transport.Clone()
+		dial := transport.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{}).DialContext
+		}
+		transport.DialContext = func(dialCtx context.Context, network, address string) (net.Conn, error) {
+			conn, err := dial(dialCtx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			return closeWhenDone(ctx, conn), nil
+		}
+		httpClient := *original.HTTPClient
+		httpClient.Transport = transport
+		copied.HTTPClient = &httpClient
+		release = transport.CloseIdleConnections
+	}
+
+	return &contextDockerClient{DockerClient: client, client: &copied}, release
+}
+
+type contextDockerClient struct {
+	dockerclient.DockerClient
+	client *docker.Client
+}
+
+func (c *contextDockerClient) Client() *docker.Client { return c.client }
+
+// contextDialer is used for the unix socket, which the Docker client dials
+// itself for streamed requests such as builds
+type contextDialer struct {
+	ctx    context.Context
+	dialer docker.Dialer
+}
+
+func (d *contextDialer) Dial(network, address string) (net.Conn, error) {
+	conn, err := d.dialer.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return closeWhenDone(d.ctx, conn), nil
+}
+
+// closeWhenDone returns conn, which is closed if ctx is done before it is
+func closeWhenDone(ctx context.Context, conn net.Conn) net.Conn {
+	ret := &contextConn{Conn: conn, closed: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-ret.closed:
+		}
+	}()
+	return ret
+}
+
+type contextConn struct {
+	net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *contextConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
@@ -0,0 +1,83 @@
+package job
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/winchman/builder-core/communication"
+)
+
+/*
+Event is a structured event reported by the build pipeline while a job is
+being processed, such as the start of a `docker build` for one of the
+container sections in the Bobfile or the completion of a `docker push`.
+*/
+type Event struct {
+	Type string                 `json:"type"`
+	Time time.Time              `json:"time"`
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+// namedEvent is an event whose type isn't one of builder-core's event types
+type namedEvent interface {
+	Name() string
+}
+
+func newEvent(e comm.Event) Event {
+	var data map[string]interface{}
+
+	if len(e.Data()) > 0 {
+		data = map[string]interface{}{}
+		for key, value := range e.Data() {
+			// errors don't serialize to anything useful on their own
+			if err, ok := value.(error); ok {
+				value = err.Error()
+			}
+			data[key] = value
+		}
+	}
+
+	eventType := e.EventType().String()
+	if named, ok := e.(namedEvent); ok {
+		eventType = named.Name()
+	}
+
+	return Event{
+		Type: eventType,
+		Time: time.Now(),
+		Data: data,
+	}
+}
+
+func (job *Job) recordEvent(e comm.Event) {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+
+	job.events = append(job.events, newEvent(e))
+	job.updateImages(e)
+}
+
+// Events returns the events recorded for the job so far, oldest first
+func (job *Job) Events() []Event {
+	job.lock.RLock()
+	defer job.lock.RUnlock()
+
+	return append([]Event{}, job.events...)
+}
+
+//GetEvents gets the events recorded for the requested job as JSON.
+func GetEvents(params martini.Params, req *http.Request) (int, string) {
+	job := store.Get(params["id"])
+	if job == nil {
+		return 404, `{"error": "job not found"}`
+	}
+
+	retBytes, err := json.Marshal(job.Events())
+	if err != nil {
+		return 409, `{"error": "` + err.Error() + `"}`
+	}
+
+	return 200, string(retBytes)
+}
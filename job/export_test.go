@@ -0,0 +1,96 @@
+package job
+
+import (
+	"context"
+
+	"github.com/rafecolton/go-dockerclient-quick"
+	"github.com/winchman/builder-core/communication"
+)
+
+// RecordEvent exposes recordEvent to the specs
+func (job *Job) RecordEvent(e comm.Event) {
+	job.recordEvent(e)
+}
+
+// Clone exposes clone to the specs
+func (job *Job) Clone() (string, error) {
+	return job.clone()
+}
+
+// ReleaseClone exposes releaseClone to the specs
+func (job *Job) ReleaseClone(path string) {
+	job.releaseClone(path)
+}
+
+// Remote exposes the url and environment of the job's remote to the specs
+func (job *Job) Remote() (string, []string, error) {
+	r, err := job.remote()
+	if err != nil {
+		return "", nil, err
+	}
+	return r.url, r.environ(), nil
+}
+
+// SetRetention sets the janitor's policy without starting it
+func SetRetention(r *Retention) {
+	janitor.lock.Lock()
+	defer janitor.lock.Unlock()
+
+	janitor.retention = r
+}
+
+// CurrentStore returns the (global) store so that specs can put it back
+func CurrentStore() Store {
+	return store
+}
+
+// PushRetry exposes the push retry policy for a section of the Bobfile at path
+func PushRetry(path, section string) (RetryPolicy, error) {
+	opts, err := readBobfileOptions(path)
+	if err != nil {
+		return RetryPolicy{}, err
+	}
+	return opts.pushRetry(section, currentRetryPolicy())
+}
+
+// Next exposes next to the specs, so that they can play the part of a worker
+func (q *Queue) Next() *Job {
+	return q.next()
+}
+
+// Done exposes done to the specs
+func (q *Queue) Done(job *Job) {
+	q.done(job)
+}
+
+// JobsCreated returns the number of jobs created for the repo and trigger
+func JobsCreated(repo, trigger string) float64 {
+	return jobsCreated.Value(repo, trigger)
+}
+
+// JobsFinished returns the number of jobs for the repo and trigger that
+// finished with the given status
+func JobsFinished(repo, trigger, status string) float64 {
+	return jobsFinished.Value(repo, trigger, status)
+}
+
+// StepCount returns the number of times the duration of step was measured
+func StepCount(step string) uint64 {
+	return stepDuration.Count(step)
+}
+
+// SetPingDocker swaps out the Docker API ping of the readiness check, returning
+// the func that puts the original back
+func SetPingDocker(fn func(context.Context) error) func() {
+	original := pingDocker
+	pingDocker = fn
+	return func() { pingDocker = original }
+}
+
+// SetDockerClient swaps out the Docker client used for builds, returning the
+// func that puts the original back
+func SetDockerClient(client dockerclient.DockerClient) func() {
+	original := newDockerClient
+	newDockerClient = func() (dockerclient.DockerClient, error) { return client, nil }
+	return func() { newDockerClient = original }
+}
@@ -0,0 +1,255 @@
+package job
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/modcloth/go-fileutils"
+)
+
+const interruptedError = "job was interrupted by a server restart"
+
+/*
+compactThreshold is how many records for old states of jobs (or for deleted
+jobs) the file may pile up before it is compacted while the server is
+running.  It's only compacted once those outnumber the live records as well.
+*/
+const compactThreshold = 1000
+
+/*
+FileStore is a Store that keeps jobs in memory and also appends every change to
+a JSON-lines file so that job history can be reloaded after a restart.  The
+file is compacted when it's opened, and again whenever it has piled up enough
+records that are no longer needed.
+*/
+type FileStore struct {
+	*MemoryStore
+	path string
+	file *os.File
+	lock sync.Mutex
+
+	// records is how many records are in the file
+	records int
+}
+
+// fileRecord is what gets written to the file for each saved job
+type fileRecord struct {
+	*Job
+	LogDir  string  `json:"log_dir"`
+	Workdir string  `json:"workdir,omitempty"`
+	Events  []Event `json:"events,omitempty"`
+
+	// Spec is kept so that the job can be retried after a restart, minus the
+	// GitHub API token, which isn't written to disk
+	Spec *Spec `json:"spec,omitempty"`
+
+	// Deleted marks a job that has been deleted since it was last saved
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+/*
+NewFileStore opens the store at path, creating it if it does not exist.  Any
+jobs already recorded in the file are reloaded, and jobs that had not finished
+when the file was last written are marked as errored since nothing will ever
+finish them.  The file is compacted to one line per job in the process.
+*/
+func NewFileStore(path string) (*FileStore, error) {
+	if err := fileutils.MkdirP(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	ret := &FileStore{MemoryStore: NewMemoryStore(), path: path}
+
+	if err := ret.load(path); err != nil {
+		return nil, err
+	}
+
+	if err := ret.compact(path); err != nil {
+		return nil, err
+	}
+
+	if err := ret.open(); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+func (s *FileStore) open() error {
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	return nil
+}
+
+func (s *FileStore) load(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var record = &fileRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			// a partially written last line is expected after a crash
+			continue
+		}
+		if record.Job == nil || record.ID == "" {
+			continue
+		}
+		if record.Deleted {
+			s.MemoryStore.Delete(record.ID)
+			continue
+		}
+
+		record.logDir = record.LogDir
+		record.Job.Workdir = record.Workdir
+		record.events = record.Events
+		record.spec = record.Spec
+		s.MemoryStore.Save(record.Job)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for _, job := range s.MemoryStore.All() {
+		if !job.finished() {
+			job.Status = StatusErrored
+			job.Error = interruptedError
+		}
+	}
+
+	return nil
+}
+
+func (s *FileStore) compact(path string) error {
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	jobs := s.MemoryStore.All()
+	for _, job := range jobs {
+		line, err := marshalRecord(job)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		writer.Write(line)
+	}
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	s.records = len(jobs)
+	return nil
+}
+
+/*
+append writes line to the file, compacting it (the same way as when it's
+opened) once it has piled up enough records for old states of jobs.  The lock
+must be held.
+*/
+func (s *FileStore) append(line []byte) error {
+	if _, err := s.file.Write(line); err != nil {
+		return err
+	}
+	s.records++
+
+	live := s.MemoryStore.count()
+	if dead := s.records - live; dead <= compactThreshold || dead <= live {
+		return nil
+	}
+
+	// if compacting fails, the old file is still there to keep appending to
+	if err := s.compact(s.path); err != nil {
+		return err
+	}
+
+	old := s.file
+	if err := s.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+// Save adds the job to the store or updates it if it already exists
+func (s *FileStore) Save(job *Job) error {
+	s.MemoryStore.Save(job)
+
+	// the snapshot is taken while holding the lock so that the last line
+	// written for a job is always its latest state
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	line, err := marshalRecord(job)
+	if err != nil {
+		return err
+	}
+
+	return s.append(line)
+}
+
+/*
+Delete removes the job with the given id from the store.  A record marking the
+job as deleted is appended to the file so that it isn't reloaded, and the job
+is dropped from the file altogether the next time it is compacted.
+*/
+func (s *FileStore) Delete(id string) error {
+	s.MemoryStore.Delete(id)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	line, err := json.Marshal(&fileRecord{Job: &Job{ID: id}, Deleted: true})
+	if err != nil {
+		return err
+	}
+
+	return s.append(append(line, '\n'))
+}
+
+func marshalRecord(job *Job) ([]byte, error) {
+	snapshot := job.Snapshot()
+
+	var spec *Spec
+	if snapshot.spec != nil {
+		stored := *snapshot.spec
+		stored.GitHubAPIToken = ""
+		spec = &stored
+	}
+
+	line, err := json.Marshal(&fileRecord{
+		Job:     snapshot,
+		LogDir:  snapshot.logDir,
+		Workdir: snapshot.Workdir,
+		Events:  job.Events(),
+		Spec:    spec,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
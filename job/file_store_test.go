@@ -0,0 +1,137 @@
+package job_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rafecolton/docker-builder/job"
+)
+
+var _ = Describe("FileStore", func() {
+	var (
+		dir  string
+		path string
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "docker-builder-store")
+		path = filepath.Join(dir, "jobs.jsonl")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("reloads saved jobs when reopened", func() {
+		s, err := NewFileStore(path)
+		Expect(err).To(BeNil())
+
+		j := &Job{ID: "foo", Account: "a", Repo: "r", Ref: "master", Status: StatusCreated}
+		Expect(s.Save(j)).To(BeNil())
+		j.Status = StatusCompleted
+		Expect(s.Save(j)).To(BeNil())
+
+		reopened, err := NewFileStore(path)
+		Expect(err).To(BeNil())
+		Expect(reopened.All()).To(HaveLen(1))
+
+		loaded := reopened.Get("foo")
+		Expect(loaded).ToNot(BeNil())
+		Expect(loaded.Account).To(Equal("a"))
+		Expect(loaded.Status).To(Equal(StatusCompleted))
+	})
+
+	It("compacts the file once it piles up enough old records", func() {
+		s, err := NewFileStore(path)
+		Expect(err).To(BeNil())
+
+		j := &Job{ID: "foo", Account: "a", Repo: "r", Ref: "master", Status: StatusCreated}
+		for i := 0; i < 2500; i++ {
+			Expect(s.Save(j)).To(BeNil())
+		}
+		j.Status = StatusCompleted
+		Expect(s.Save(j)).To(BeNil())
+
+		contents, _ := ioutil.ReadFile(path)
+		Expect(strings.Count(string(contents), "\n")).To(BeNumerically("<=", 1002))
+
+		reopened, err := NewFileStore(path)
+		Expect(err).To(BeNil())
+		Expect(reopened.All()).To(HaveLen(1))
+		Expect(reopened.Get("foo").Status).To(Equal(StatusCompleted))
+	})
+
+	It("keeps the spec of a job, minus its API token, so that it can be retried", func() {
+		s, err := NewFileStore(path)
+		Expect(err).To(BeNil())
+
+		spec, _ := NewSpec([]byte(`{"account": "a", "repo": "r", "ref": "master", "bobfile": "Bobfile.app", "api_token": "secret"}`))
+		req, _ := makeRequest("POST", "jobs", nil)
+		j := NewJob(&Config{Workdir: dir, Logger: logrus.New()}, spec, req)
+		j.Status = StatusErrored
+		Expect(s.Save(j)).To(BeNil())
+
+		contents, _ := ioutil.ReadFile(path)
+		Expect(string(contents)).ToNot(ContainSubstring("secret"))
+
+		reopened, err := NewFileStore(path)
+		Expect(err).To(BeNil())
+
+		retry, err := reopened.Get(j.ID).RetrySpec()
+		Expect(err).To(BeNil())
+		Expect(retry.RetryOf).To(Equal(j.ID))
+		Expect(retry.Bobfile).To(Equal("Bobfile.app"))
+		Expect(retry.GitRef).To(Equal("master"))
+		Expect(retry.GitHubAPIToken).To(BeEmpty())
+	})
+
+	It("marks jobs that were in progress as errored", func() {
+		s, err := NewFileStore(path)
+		Expect(err).To(BeNil())
+		Expect(s.Save(&Job{ID: "cloning", Status: StatusCloning})).To(BeNil())
+		Expect(s.Save(&Job{ID: "building", Status: StatusBuilding})).To(BeNil())
+
+		reopened, err := NewFileStore(path)
+		Expect(err).To(BeNil())
+
+		for _, id := range []string{"cloning", "building"} {
+			loaded := reopened.Get(id)
+			Expect(loaded.Status).To(Equal(StatusErrored))
+			Expect(loaded.Error).ToNot(BeEmpty())
+		}
+	})
+
+	It("ignores a partially written last line", func() {
+		s, err := NewFileStore(path)
+		Expect(err).To(BeNil())
+		Expect(s.Save(&Job{ID: "foo", Status: StatusCompleted})).To(BeNil())
+
+		file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		file.WriteString(`{"id": "bar", "sta`)
+		file.Close()
+
+		reopened, err := NewFileStore(path)
+		Expect(err).To(BeNil())
+		Expect(reopened.All()).To(HaveLen(1))
+	})
+
+	It("forgets deleted jobs when reopened", func() {
+		s, err := NewFileStore(path)
+		Expect(err).To(BeNil())
+		Expect(s.Save(&Job{ID: "foo", Status: StatusCompleted, Workdir: "/tmp/foo"})).To(BeNil())
+		Expect(s.Save(&Job{ID: "bar", Status: StatusCompleted})).To(BeNil())
+		Expect(s.Delete("bar")).To(BeNil())
+		Expect(s.Get("bar")).To(BeNil())
+
+		reopened, err := NewFileStore(path)
+		Expect(err).To(BeNil())
+		Expect(reopened.All()).To(HaveLen(1))
+		Expect(reopened.Get("foo").Workdir).To(Equal("/tmp/foo"))
+	})
+})
@@ -0,0 +1,97 @@
+package job
+
+import (
+	"github.com/winchman/builder-core/communication"
+)
+
+/*
+Image describes what one of the container sections in a job's Bobfile
+produced: the image that was built, the tags it was given (with any
+`{{ sha }}`-style tags already evaluated), and the outcome of each push.
+*/
+type Image struct {
+	ContainerSection string   `json:"container_section"`
+	ImageID          string   `json:"image_id,omitempty"`
+	Registry         string   `json:"registry,omitempty"`
+	Repo             string   `json:"repo,omitempty"`
+	Tags             []string `json:"tags,omitempty"`
+	Pushes           []Push   `json:"pushes,omitempty"`
+}
+
+/*
+Push is the outcome of pushing one tag of an Image.  Digest is the manifest
+digest reported by the registry, if any.
+*/
+type Push struct {
+	Tag       string `json:"tag"`
+	Succeeded bool   `json:"succeeded"`
+	Digest    string `json:"digest,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func copyImages(images []Image) []Image {
+	if images == nil {
+		return nil
+	}
+
+	ret := make([]Image, len(images))
+	for i, image := range images {
+		image.Tags = append([]string(nil), image.Tags...)
+		image.Pushes = append([]Push(nil), image.Pushes...)
+		ret[i] = image
+	}
+	return ret
+}
+
+// updateImages updates the job's images from a build event.  The caller must
+// hold the job's lock.
+func (job *Job) updateImages(e comm.Event) {
+	data := e.Data()
+	section, _ := data["container_section"].(string)
+	err, _ := data["error"].(error)
+
+	switch e.EventType() {
+	case comm.BuildCompletedEvent:
+		if err != nil {
+			return
+		}
+		imageID, _ := data["image_id"].(string)
+		job.Images = append(job.Images, Image{
+			ContainerSection: section,
+			ImageID:          imageID,
+		})
+	case comm.TagCompletedEvent:
+		if err != nil {
+			return
+		}
+		if image := job.image(section); image != nil {
+			image.Repo, _ = data["repo"].(string)
+			tag, _ := data["tag"].(string)
+			image.Tags = append(image.Tags, tag)
+		}
+	case comm.PushEvent:
+		if !isPushCompletedEvent(e) {
+			return
+		}
+		if image := job.image(section); image != nil {
+			image.Registry, _ = data["registry"].(string)
+			push := Push{Succeeded: err == nil}
+			push.Tag, _ = data["tag"].(string)
+			push.Digest, _ = data["digest"].(string)
+			if err != nil {
+				push.Error = err.Error()
+			}
+			image.Pushes = append(image.Pushes, push)
+		}
+	}
+}
+
+// image returns the most recently built image for the container section
+func (job *Job) image(section string) *Image {
+	for i := len(job.Images) - 1; i >= 0; i-- {
+		if job.Images[i].ContainerSection == section {
+			return &job.Images[i]
+		}
+	}
+	return nil
+}
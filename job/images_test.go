@@ -0,0 +1,63 @@
+package job_test
+
+import (
+	"errors"
+
+	"github.com/winchman/builder-core/communication"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rafecolton/docker-builder/job"
+)
+
+var _ = Describe("recording images", func() {
+	var (
+		subject  *Job
+		events   comm.EventChan
+		reporter *comm.Reporter
+	)
+
+	report := func(eventType comm.EventType, data map[string]interface{}) {
+		data["container_section"] = "app"
+		reporter.Event(comm.EventOptions{EventType: eventType, Data: data})
+		subject.RecordEvent(<-events)
+	}
+
+	BeforeEach(func() {
+		subject = &Job{ID: "images"}
+		events = make(comm.EventChan, 1)
+		reporter = comm.NewReporter(nil, events)
+	})
+
+	It("records the image, its tags and the outcome of each push", func() {
+		report(comm.BuildCompletedEvent, map[string]interface{}{"image_id": "abc123", "error": nil})
+		report(comm.TagCompletedEvent, map[string]interface{}{"repo": "quay.io/foo/app", "tag": "latest", "error": nil})
+		report(comm.TagCompletedEvent, map[string]interface{}{"repo": "quay.io/foo/app", "tag": "deadbeef", "error": nil})
+		report(comm.PushEvent, map[string]interface{}{"registry": "quay.io", "repo": "quay.io/foo/app", "tag": "latest"})
+		report(comm.PushEvent, map[string]interface{}{
+			"registry": "quay.io", "repo": "quay.io/foo/app", "tag": "latest", "error": nil,
+			"digest": "sha256:0123",
+		})
+		report(comm.PushEvent, map[string]interface{}{
+			"registry": "quay.io", "repo": "quay.io/foo/app", "tag": "deadbeef", "error": errors.New("denied"),
+		})
+
+		images := subject.Snapshot().Images
+		Expect(images).To(HaveLen(1))
+		Expect(images[0].ContainerSection).To(Equal("app"))
+		Expect(images[0].ImageID).To(Equal("abc123"))
+		Expect(images[0].Registry).To(Equal("quay.io"))
+		Expect(images[0].Repo).To(Equal("quay.io/foo/app"))
+		Expect(images[0].Tags).To(Equal([]string{"latest", "deadbeef"}))
+		Expect(images[0].Pushes).To(Equal([]Push{
+			{Tag: "latest", Succeeded: true, Digest: "sha256:0123"},
+			{Tag: "deadbeef", Succeeded: false, Error: "denied"},
+		}))
+	})
+
+	It("does not record an image for a failed build", func() {
+		report(comm.BuildCompletedEvent, map[string]interface{}{"image_id": "", "error": errors.New("boom")})
+
+		Expect(subject.Snapshot().Images).To(BeEmpty())
+	})
+})
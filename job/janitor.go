@@ -0,0 +1,323 @@
+package job
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/go-units"
+	"github.com/modcloth/go-fileutils"
+)
+
+const (
+	// DefaultJanitorInterval is how often the janitor enforces the retention
+	// policy when no interval is configured
+	DefaultJanitorInterval = 10 * time.Minute
+
+	// janitorHistory is the number of removals the janitor remembers
+	janitorHistory = 100
+)
+
+// The reasons for which the janitor removes a job
+const (
+	RemovedForAge   = "max_age"
+	RemovedForCount = "max_jobs"
+	RemovedForDisk  = "max_disk"
+)
+
+/*
+Retention is the policy the janitor enforces for finished jobs.  Jobs that
+finished more than MaxAge ago are removed, then the oldest finished jobs are
+removed until at most MaxJobs of them are left and until the workdirs and logs
+of all jobs (including unfinished ones) take up at most MaxDisk bytes.  A zero
+value means no limit, and jobs that haven't finished are never removed.
+*/
+type Retention struct {
+	MaxAge   time.Duration
+	MaxJobs  int
+	MaxDisk  int64
+	Interval time.Duration
+}
+
+// Removal is a job that was removed by the janitor, and why
+type Removal struct {
+	JobID     string    `json:"job_id"`
+	Account   string    `json:"account,omitempty"`
+	Repo      string    `json:"repo,omitempty"`
+	Ref       string    `json:"ref,omitempty"`
+	Status    string    `json:"status"`
+	Completed time.Time `json:"completed"`
+	Removed   time.Time `json:"removed"`
+	Reason    string    `json:"reason"`
+	Bytes     int64     `json:"bytes"`
+}
+
+/*
+JanitorReport is what the janitor has been up to: its policy, when it last
+ran, and the jobs it has removed (most recent first).
+*/
+type JanitorReport struct {
+	Enabled      bool      `json:"enabled"`
+	MaxAge       string    `json:"max_age,omitempty"`
+	MaxJobs      int       `json:"max_jobs,omitempty"`
+	MaxDisk      string    `json:"max_disk,omitempty"`
+	Interval     string    `json:"interval,omitempty"`
+	LastRun      time.Time `json:"last_run,omitempty"`
+	TotalRemoved int       `json:"total_removed"`
+	TotalBytes   int64     `json:"total_bytes"`
+	Removed      []Removal `json:"removed"`
+}
+
+var janitor = &janitorState{}
+
+type janitorState struct {
+	retention    *Retention
+	lastRun      time.Time
+	totalRemoved int
+	totalBytes   int64
+	removed      []Removal
+	lock         sync.Mutex
+}
+
+/*
+StartJanitor starts enforcing the retention policy in the background, every
+r.Interval (or DefaultJanitorInterval).
+*/
+func StartJanitor(r Retention) {
+	if r.Interval <= 0 {
+		r.Interval = DefaultJanitorInterval
+	}
+
+	janitor.lock.Lock()
+	janitor.retention = &r
+	janitor.lock.Unlock()
+
+	go func() {
+		for {
+			Sweep()
+			time.Sleep(r.Interval)
+		}
+	}()
+}
+
+/*
+Sweep enforces the retention policy once, deleting the workdirs, logs and
+records of the jobs it removes, and returns the removals.  Nothing is removed
+if no policy has been set with StartJanitor.
+*/
+func Sweep() []Removal {
+	janitor.lock.Lock()
+	defer janitor.lock.Unlock()
+
+	if janitor.retention == nil {
+		return []Removal{}
+	}
+
+	removals := sweep(*janitor.retention, store.All(), time.Now())
+
+	janitor.lastRun = time.Now()
+	janitor.totalRemoved += len(removals)
+	for i := range removals {
+		janitor.totalBytes += removals[i].Bytes
+	}
+
+	// most recent sweep first
+	janitor.removed = append(removals, janitor.removed...)
+	if len(janitor.removed) > janitorHistory {
+		janitor.removed = janitor.removed[:janitorHistory]
+	}
+
+	return removals
+}
+
+// sweepEntry is a job along with the dirs it takes up on disk
+type sweepEntry struct {
+	job   *Job
+	dirs  []string
+	bytes int64
+}
+
+func sweep(r Retention, jobs []*Job, now time.Time) []Removal {
+	var finished []*sweepEntry
+	var total int64
+	inUse := map[string]int{}
+
+	for _, job := range jobs {
+		snapshot := job.Snapshot()
+		entry := &sweepEntry{job: snapshot}
+		for _, dir := range []string{snapshot.Workdir, snapshot.logDir} {
+			if dir != "" {
+				entry.dirs = append(entry.dirs, dir)
+				inUse[dir]++
+			}
+		}
+		entry.bytes = diskUsage(entry.dirs)
+		total += entry.bytes
+
+		if job.finished() {
+			finished = append(finished, entry)
+		}
+	}
+
+	// oldest first
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].job.Completed.Before(finished[j].job.Completed)
+	})
+
+	var removals = []Removal{}
+	remove := func(entry *sweepEntry, reason string) {
+		removals = append(removals, removeJob(entry, reason, inUse, now))
+		total -= entry.bytes
+	}
+
+	var kept []*sweepEntry
+	for _, entry := range finished {
+		if r.MaxAge > 0 && now.Sub(entry.job.Completed) > r.MaxAge {
+			remove(entry, RemovedForAge)
+			continue
+		}
+		kept = append(kept, entry)
+	}
+
+	for r.MaxJobs > 0 && len(kept) > r.MaxJobs {
+		remove(kept[0], RemovedForCount)
+		kept = kept[1:]
+	}
+
+	for r.MaxDisk > 0 && total > r.MaxDisk && len(kept) > 0 {
+		remove(kept[0], RemovedForDisk)
+		kept = kept[1:]
+	}
+
+	return removals
+}
+
+/*
+removeJob deletes the job's record along with its workdir and logs.  A dir that
+is shared with another job (e.g. a workdir that's also the parent of the logs)
+is only deleted along with the last job using it.
+*/
+func removeJob(entry *sweepEntry, reason string, inUse map[string]int, now time.Time) Removal {
+	job := entry.job
+
+	for _, dir := range entry.dirs {
+		inUse[dir]--
+		if inUse[dir] == 0 {
+			fileutils.RmRF(dir)
+		}
+	}
+
+	if err := store.Delete(job.ID); err != nil && logger != nil {
+		logger.WithFields(logrus.Fields{"job_id": job.ID, "error": err}).Error("unable to delete job")
+	}
+
+	removal := Removal{
+		JobID:     job.ID,
+		Account:   job.Account,
+		Repo:      job.Repo,
+		Ref:       job.Ref,
+		Status:    job.Status,
+		Completed: job.Completed,
+		Removed:   now,
+		Reason:    reason,
+		Bytes:     entry.bytes,
+	}
+
+	if logger != nil {
+		logger.WithFields(logrus.Fields{
+			"job_id":    removal.JobID,
+			"account":   removal.Account,
+			"repo":      removal.Repo,
+			"completed": removal.Completed,
+			"reason":    removal.Reason,
+			"bytes":     removal.Bytes,
+		}).Info("janitor removed job")
+	}
+
+	return removal
+}
+
+// diskUsage is the total size of the files under dirs, skipping any that are
+// nested in one of the others so that they aren't counted twice
+func diskUsage(dirs []string) int64 {
+	var total int64
+	for i, dir := range dirs {
+		nested := false
+		for j, other := range dirs {
+			if i != j && isUnder(dir, other) {
+				nested = true
+			}
+		}
+		if nested {
+			continue
+		}
+
+		filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				total += info.Size()
+			}
+			return nil
+		})
+	}
+	return total
+}
+
+func isUnder(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Report returns what the janitor has been up to
+func Report() *JanitorReport {
+	janitor.lock.Lock()
+	defer janitor.lock.Unlock()
+
+	ret := &JanitorReport{
+		LastRun:      janitor.lastRun,
+		TotalRemoved: janitor.totalRemoved,
+		TotalBytes:   janitor.totalBytes,
+		Removed:      append([]Removal{}, janitor.removed...),
+	}
+
+	if r := janitor.retention; r != nil {
+		ret.Enabled = true
+		ret.Interval = r.Interval.String()
+		ret.MaxJobs = r.MaxJobs
+		if r.MaxAge > 0 {
+			ret.MaxAge = r.MaxAge.String()
+		}
+		if r.MaxDisk > 0 {
+			ret.MaxDisk = units.BytesSize(float64(r.MaxDisk))
+		}
+	}
+
+	return ret
+}
+
+//GetJanitor is the handler function for the janitor's admin route.
+func GetJanitor() (int, string) {
+	retBytes, err := json.Marshal(Report())
+	if err != nil {
+		return 409, `{"error": "` + err.Error() + `"}`
+	}
+
+	return 200, string(retBytes)
+}
+
+/*
+SweepNow is the handler function for running the janitor right away.  It
+responds with the jobs that were removed.
+*/
+func SweepNow() (int, string) {
+	retBytes, err := json.Marshal(Sweep())
+	if err != nil {
+		return 409, `{"error": "` + err.Error() + `"}`
+	}
+
+	return 200, string(retBytes)
+}
@@ -0,0 +1,119 @@
+package job_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rafecolton/docker-builder/job"
+)
+
+var _ = Describe("janitor", func() {
+	var (
+		tmpdir   string
+		original Store
+		testJobs Store
+	)
+
+	// addJob saves a job whose workdir has size bytes in it
+	addJob := func(id, status string, completed time.Time, size int) *Job {
+		workdir := filepath.Join(tmpdir, id)
+		os.MkdirAll(workdir, 0755)
+		ioutil.WriteFile(workdir+"/build.log", []byte(strings.Repeat("x", size)), 0644)
+
+		job := &Job{ID: id, Status: status, Completed: completed, Workdir: workdir}
+		testJobs.Save(job)
+		return job
+	}
+
+	BeforeEach(func() {
+		tmpdir, _ = ioutil.TempDir("", "janitor")
+		original = CurrentStore()
+		testJobs = NewMemoryStore()
+		SetStore(testJobs)
+	})
+
+	AfterEach(func() {
+		SetRetention(nil)
+		SetStore(original)
+		os.RemoveAll(tmpdir)
+	})
+
+	It("does nothing without a retention policy", func() {
+		addJob("old", StatusCompleted, time.Now().Add(-time.Hour), 10)
+
+		Expect(Sweep()).To(BeEmpty())
+		Expect(testJobs.Get("old")).ToNot(BeNil())
+	})
+
+	It("removes jobs that finished longer ago than the max age", func() {
+		SetRetention(&Retention{MaxAge: time.Hour})
+		addJob("old", StatusErrored, time.Now().Add(-2*time.Hour), 10)
+		addJob("recent", StatusCompleted, time.Now().Add(-time.Minute), 10)
+		addJob("running", StatusBuilding, time.Time{}, 10)
+
+		removals := Sweep()
+
+		Expect(removals).To(HaveLen(1))
+		Expect(removals[0].JobID).To(Equal("old"))
+		Expect(removals[0].Reason).To(Equal(RemovedForAge))
+		Expect(removals[0].Bytes).To(Equal(int64(10)))
+		Expect(testJobs.Get("old")).To(BeNil())
+		Expect(filepath.Join(tmpdir, "old")).ToNot(BeAnExistingFile())
+		Expect(testJobs.Get("recent")).ToNot(BeNil())
+		Expect(testJobs.Get("running")).ToNot(BeNil())
+	})
+
+	It("keeps only the newest finished jobs", func() {
+		SetRetention(&Retention{MaxJobs: 2})
+		for i, id := range []string{"first", "second", "third"} {
+			addJob(id, StatusCompleted, time.Now().Add(time.Duration(i)*time.Minute), 10)
+		}
+		addJob("queued", StatusQueued, time.Time{}, 10)
+
+		removals := Sweep()
+
+		Expect(removals).To(HaveLen(1))
+		Expect(removals[0].JobID).To(Equal("first"))
+		Expect(removals[0].Reason).To(Equal(RemovedForCount))
+		Expect(testJobs.All()).To(HaveLen(3))
+	})
+
+	It("removes the oldest finished jobs until under the max disk usage", func() {
+		SetRetention(&Retention{MaxDisk: 250})
+		addJob("first", StatusCompleted, time.Now().Add(-3*time.Minute), 100)
+		addJob("second", StatusCancelled, time.Now().Add(-2*time.Minute), 100)
+		addJob("third", StatusCompleted, time.Now().Add(-time.Minute), 100)
+		addJob("running", StatusCloning, time.Time{}, 100)
+
+		removals := Sweep()
+
+		Expect(removals).To(HaveLen(2))
+		Expect(removals[0].JobID).To(Equal("first"))
+		Expect(removals[1].JobID).To(Equal("second"))
+		Expect(removals[1].Reason).To(Equal(RemovedForDisk))
+		Expect(testJobs.Get("third")).ToNot(BeNil())
+	})
+
+	It("reports what it has removed", func() {
+		SetRetention(&Retention{MaxAge: time.Hour, Interval: time.Minute})
+		addJob("old", StatusCompleted, time.Now().Add(-2*time.Hour), 10)
+		Sweep()
+
+		code, body := GetJanitor()
+		var report = &JanitorReport{}
+		json.Unmarshal([]byte(body), report)
+
+		Expect(code).To(Equal(200))
+		Expect(report.Enabled).To(BeTrue())
+		Expect(report.MaxAge).To(Equal("1h0m0s"))
+		Expect(report.LastRun.IsZero()).To(BeFalse())
+		Expect(report.TotalRemoved).To(BeNumerically(">=", 1))
+		Expect(report.Removed[0].JobID).To(Equal("old"))
+	})
+})
@@ -1,17 +1,21 @@
 package job
 
 import (
+	"context"
+	"errors"
 	"io"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/modcloth/go-fileutils"
-	"github.com/modcloth/kamino"
 	gouuid "github.com/nu7hatch/gouuid"
 	"github.com/winchman/builder-core"
+	"github.com/winchman/builder-core/communication"
 	"github.com/winchman/builder-core/unit-config"
 
 	"github.com/rafecolton/docker-builder/conf"
@@ -23,6 +27,30 @@ const (
 	specFixturesRepoDir = "./_testing/fixtures/repodir"
 )
 
+// The statuses a job may have
+const (
+	StatusCreated    = "created"
+	StatusQueued     = "queued"
+	StatusCloning    = "cloning"
+	StatusBuilding   = "building"
+	StatusCompleted  = "completed"
+	StatusErrored    = "errored"
+	StatusCancelled  = "cancelled"
+	StatusTimedOut   = "timed_out"
+	StatusSuperseded = "superseded"
+	StatusValidating = "validating"
+)
+
+// The triggers a job may have, i.e. what received the request for it
+const (
+	TriggerAPI       = "api"
+	TriggerBitbucket = "bitbucket"
+	TriggerGitea     = "gitea"
+	TriggerGitHub    = "github"
+	TriggerGitLab    = "gitlab"
+	TriggerTravis    = "travis"
+)
+
 var (
 	// TestMode monkeys with certain things for tests so bad things don't happen
 	TestMode bool
@@ -30,19 +58,32 @@ var (
 	// SkipPush indicates whether or not a global --skip-push directive has been given
 	SkipPush bool
 
+	// DefaultTimeout is how long a job may take (once it has left the queue)
+	// if its spec doesn't say.  Zero means no timeout.
+	DefaultTimeout time.Duration
+
 	logger *logrus.Logger
+
+	// ErrNotCancellable is returned when cancelling a job that has already finished
+	ErrNotCancellable = errors.New("job has already finished")
 )
 
 /*
 Job is the struct representation of a build job.  Intended to be
 created with NewJob, but exported so it can be used for tests.
+
+A job's status changes while it is being processed, so use Snapshot to get a
+copy that is safe to read or serialize from other goroutines.
 */
 type Job struct {
 	Account            string         `json:"account,omitempty"`
 	Bobfile            string         `json:"bobfile,omitempty"`
+	Branch             string         `json:"branch,omitempty"`
+	CloneURL           string         `json:"clone_url,omitempty"`
+	Commit             string         `json:"commit,omitempty"`
 	Completed          time.Time      `json:"completed,omitempty"`
 	Created            time.Time      `json:"created"`
-	Error              error          `json:"error,omitempty"`
+	Error              string         `json:"error,omitempty"`
 	GitCloneDepth      string         `json:"clone_depth,omitempty"`
 	GitHubAPIToken     string         `json:"-"`
 	ID                 string         `json:"id,omitempty"`
@@ -51,11 +92,31 @@ type Job struct {
 	Ref                string         `json:"ref,omitempty"`
 	Repo               string         `json:"repo,omitempty"`
 	Status             string         `json:"status"`
+	Submitter          string         `json:"submitter,omitempty"`
+	Timeout            string         `json:"timeout,omitempty"`
+	Recursive          bool           `json:"recursive,omitempty"`
+	Submodules         []Submodule    `json:"submodules,omitempty"`
+	Uploaded           bool           `json:"uploaded,omitempty"`
+	QueuePosition      int            `json:"queue_position,omitempty"`
 	Workdir            string         `json:"-"`
 	InfoRoute          string         `json:"info_route,omitempty"`
+	Trigger            string         `json:"trigger,omitempty"`
+	Images             []Image        `json:"images,omitempty"`
+	RetryOf            string         `json:"retry_of,omitempty"`
+	RetriedBy          []string       `json:"retried_by,omitempty"`
+	SupersededBy       string         `json:"superseded_by,omitempty"`
+	TokenName          string         `json:"token_name,omitempty"`
 	logDir             string         `json:"-"`
 	logFile            *os.File       `json:"-"`
 	clonedRepoLocation string         `json:"-"`
+	contextDir         string
+	spec               *Spec
+	timeout            time.Duration
+	releaseCache       func()
+	ctx                context.Context
+	cancel             context.CancelFunc
+	events             []Event
+	lock               sync.RWMutex
 }
 
 /*
@@ -119,20 +180,47 @@ func NewJob(cfg *Config, spec *Spec, req *http.Request) *Job {
 		bobfile = defaultBobfile
 	}
 
+	logDir := cfg.Workdir + "/" + id
+	if logRoot != "" {
+		logDir = logRoot + "/" + id
+	}
+
+	timeout := DefaultTimeout
+	if spec.Timeout != "" {
+		// the spec has already been validated
+		timeout, _ = time.ParseDuration(spec.Timeout)
+	}
+
 	ret := &Job{
 		Bobfile:        bobfile,
+		Branch:         spec.Branch,
+		CloneURL:       spec.CloneURL,
 		ID:             id,
 		Account:        spec.RepoOwner,
+		GitCloneDepth:  spec.Depth,
 		GitHubAPIToken: spec.GitHubAPIToken,
+		Recursive:      spec.Submodules,
 		Ref:            spec.GitRef,
 		Repo:           spec.RepoName,
+		RetryOf:        spec.RetryOf,
+		Submitter:      spec.Submitter,
+		TokenName:      spec.TokenName,
+		Trigger:        spec.Trigger,
+		Uploaded:       spec.ContextDir != "",
 		Workdir:        cfg.Workdir,
+		contextDir:     spec.ContextDir,
+		spec:           storedSpec(spec),
+		timeout:        timeout,
 		InfoRoute:      "/jobs/" + id,
 		LogRoute:       "/jobs/" + id + "/tail?n=" + defaultTail,
-		logDir:         cfg.Workdir + "/" + id,
-		Status:         "created",
+		logDir:         logDir,
+		Status:         StatusCreated,
 		Created:        time.Now(),
 	}
+	if timeout > 0 {
+		ret.Timeout = timeout.String()
+	}
+	ret.ctx, ret.cancel = context.WithCancel(context.Background())
 	ret.addHostToRoutes(req)
 
 	out, file, err := newMultiWriter(ret.logDir)
@@ -153,7 +241,13 @@ func NewJob(cfg *Config, spec *Spec, req *http.Request) *Job {
 	}
 
 	if id != "" {
-		jobs[id] = ret
+		if err := store.Save(ret); err != nil {
+			cfg.Logger.WithField("error", err).Error("error saving job")
+		}
+		notify(ret)
+		reportCommitStatus(ret)
+		ret.linkRetry()
+		jobsCreated.Inc(ret.repoKey(), ret.Trigger)
 	}
 
 	return ret
@@ -180,64 +274,6 @@ func newMultiWriter(logDir string) (io.Writer, *os.File, error) {
 	return out, file, nil
 }
 
-func (job *Job) clone() (string, error) {
-	job.Logger.WithFields(logrus.Fields{
-		"api_token_present":  job.GitHubAPIToken != "",
-		"account":            job.Account,
-		"ref":                job.Ref,
-		"repo":               job.Repo,
-		"clone_cache_option": kamino.No,
-	}).Info("starting clone process")
-
-	genome := &kamino.Genome{
-		APIToken: job.GitHubAPIToken,
-		Account:  job.Account,
-		Ref:      job.Ref,
-		Repo:     job.Repo,
-		UseCache: kamino.No,
-	}
-
-	factory, err := kamino.NewCloneFactory(job.Workdir)
-	if err != nil {
-		job.Logger.WithFields(logrus.Fields{
-			"api_token_present":  job.GitHubAPIToken != "",
-			"account":            job.Account,
-			"ref":                job.Ref,
-			"repo":               job.Repo,
-			"clone_cache_option": kamino.No,
-			"error":              err,
-		}).Error("issue creating clone factory")
-
-		return "", err
-	}
-
-	job.Logger.Debug("attempting to clone")
-
-	path, err := factory.Clone(genome)
-	if err != nil {
-		job.Logger.WithFields(logrus.Fields{
-			"api_token_present":  job.GitHubAPIToken != "",
-			"account":            job.Account,
-			"ref":                job.Ref,
-			"repo":               job.Repo,
-			"clone_cache_option": kamino.No,
-			"error":              err,
-		}).Error("issue cloning")
-
-		return "", err
-	}
-
-	job.Logger.WithFields(logrus.Fields{
-		"api_token_present":  job.GitHubAPIToken != "",
-		"account":            job.Account,
-		"ref":                job.Ref,
-		"repo":               job.Repo,
-		"clone_cache_option": kamino.No,
-	}).Info("cloning successful")
-
-	return path, nil
-}
-
 func (job *Job) build() error {
 
 	job.Logger.Debug("attempting to create a builder")
@@ -256,13 +292,51 @@ func (job *Job) build() error {
 
 	unitConfig.SetGlobals(globals)
 
+	opts, err := readBobfileOptions(job.clonedRepoLocation + "/" + job.Bobfile)
+	if err != nil {
+		job.Logger.WithField("error", err).Error("issue parsing Bobfile")
+		return err
+	}
+
+	// check the retry settings up front rather than when the first push fails
+	policy := currentRetryPolicy()
+	for _, section := range append([]containerOptions{{}}, opts.Containers...) {
+		if _, err := opts.pushRetry(section.Name, policy); err != nil {
+			job.Logger.WithField("error", err).Error("issue parsing Bobfile")
+			return err
+		}
+	}
+
 	job.Logger.WithField("file", job.Bobfile).Info("building from file")
 
-	return runner.RunBuildSynchronously(runner.Options{
+	log, event, exit := runBuild(job.ctx, runner.Options{
 		UnitConfig: unitConfig,
 		ContextDir: job.clonedRepoLocation,
-		LogLevel:   job.Logger.Level,
+	}, func(section string) RetryPolicy {
+		ret, _ := opts.pushRetry(section, policy)
+		return ret
 	})
+
+	for {
+		select {
+		case e, ok := <-log:
+			if !ok {
+				return errors.New("log channel closed prematurely")
+			}
+			e.LogWithLogger(job.Logger)
+		case e, ok := <-event:
+			if !ok {
+				return errors.New("event channel closed prematurely")
+			}
+			job.Logger.WithFields(e.Data()).Debugf("status event (type %s)", e.EventType())
+			job.recordEvent(e)
+		case err, ok := <-exit:
+			if !ok {
+				return errors.New("exit channel closed prematurely")
+			}
+			return err
+		}
+	}
 }
 
 /*
@@ -270,52 +344,234 @@ Process does the actual job processing work, including:
 
 	1. clone the repo
 	2. build from the Bobfile at the top level
-	3. clean up the cloned repo
+	3. clean up the cloned repo (or release it, if it's cached)
 */
 func (job *Job) Process() error {
 	if TestMode {
 		return job.processTestMode()
 	}
 
-	defer func() {
-		if job.logFile != nil {
-			job.logFile.Close()
-		}
-	}()
+	defer job.closeLog()
 
-	// step 1: clone
-	job.Status = "cloning"
-	path, err := job.clone()
-	if err != nil {
-		job.Logger.WithField("error", err).Error("unable to process job synchronously")
-		job.Status = "errored"
-		job.Error = err
+	atomic.AddInt64(&inFlight, 1)
+	defer atomic.AddInt64(&inFlight, -1)
+
+	// the job may have been cancelled while it was waiting to be processed
+	if err := job.ctx.Err(); err != nil {
+		job.fail(err)
+		job.removeUpload()
 		return err
 	}
+
+	// the timeout only starts once the job has left the queue.  Nothing else
+	// uses job.ctx while the job is being processed, and cancelling the job
+	// still cancels the new context since it's derived from the old one.
+	if job.timeout > 0 {
+		ctx, cancel := context.WithTimeout(job.ctx, job.timeout)
+		defer cancel()
+		job.ctx = ctx
+	}
+
+	// step 1: clone (unless the build context was uploaded)
+	path := job.contextDir
+	if path == "" {
+		var err error
+		job.setStatus(StatusCloning)
+		start := time.Now()
+		path, err = job.clone()
+		observeStep(StepClone, start)
+		if err != nil {
+			job.fail(err)
+			return err
+		}
+	} else {
+		job.Logger.WithField("submitter", job.Submitter).Info("building uploaded context")
+	}
 	job.clonedRepoLocation = path
 
 	// step 2: build
-	job.Status = "building"
-	if err = job.build(); err != nil {
-		job.Logger.WithField("error", err).Error("unable to process job synchronously")
-		job.Status = "errored"
-		job.Error = err
+	job.setStatus(StatusBuilding)
+	if err := job.build(); err != nil {
+		job.fail(err)
+		job.releaseClone(path)
 		return err
 	}
 
-	job.Status = "completed"
-	job.Completed = time.Now()
-	fileutils.RmRF(path)
+	job.finish(StatusCompleted, "")
+	job.releaseClone(path)
 	return nil
 }
 
+/*
+Snapshot returns a copy of the job's current state that is safe to read and
+serialize while the job is still being processed.
+*/
+func (job *Job) Snapshot() *Job {
+	position := queue.Position(job.ID)
+
+	job.lock.RLock()
+	defer job.lock.RUnlock()
+
+	return &Job{
+		Account:        job.Account,
+		Bobfile:        job.Bobfile,
+		Branch:         job.Branch,
+		CloneURL:       job.CloneURL,
+		Commit:         job.Commit,
+		Completed:      job.Completed,
+		Created:        job.Created,
+		Error:          job.Error,
+		GitCloneDepth:  job.GitCloneDepth,
+		GitHubAPIToken: job.GitHubAPIToken,
+		ID:             job.ID,
+		LogRoute:       job.LogRoute,
+		Logger:         job.Logger,
+		Ref:            job.Ref,
+		Repo:           job.Repo,
+		Status:         job.Status,
+		Submitter:      job.Submitter,
+		Timeout:        job.Timeout,
+		Recursive:      job.Recursive,
+		Submodules:     copySubmodules(job.Submodules),
+		Uploaded:       job.Uploaded,
+		QueuePosition:  position,
+		Workdir:        job.Workdir,
+		InfoRoute:      job.InfoRoute,
+		Trigger:        job.Trigger,
+		Images:         copyImages(job.Images),
+		RetryOf:        job.RetryOf,
+		RetriedBy:      append([]string(nil), job.RetriedBy...),
+		SupersededBy:   job.SupersededBy,
+		TokenName:      job.TokenName,
+		logDir:         job.logDir,
+		spec:           job.spec,
+	}
+}
+
+// update applies fn to the job while holding its lock, then saves the job
+func (job *Job) update(fn func()) {
+	job.lock.Lock()
+	status := job.Status
+	fn()
+	changed := job.Status != status
+	finished := changed && finishedStatus(job.Status)
+	newStatus := job.Status
+	job.lock.Unlock()
+
+	job.save()
+
+	if changed {
+		notify(job)
+		reportCommitStatus(job)
+	}
+	if finished {
+		jobsFinished.Inc(job.repoKey(), job.Trigger, newStatus)
+	}
+}
+
+// setStatus updates the status of the job and saves the change to the store
+func (job *Job) setStatus(status string) {
+	job.update(func() { job.Status = status })
+}
+
+// finish moves the job to a terminal status
+func (job *Job) finish(status, errMessage string) {
+	job.update(func() {
+		job.Status = status
+		job.Error = errMessage
+		job.Completed = time.Now()
+	})
+}
+
+/*
+Cancel stops the job.  A job that is still waiting in the queue is simply
+removed from it, while a job that is being processed has the clone, build or
+push it is in the middle of aborted.  ErrNotCancellable is returned if the job has already
+finished.
+*/
+func (job *Job) Cancel() error {
+	if job.finished() || job.cancel == nil {
+		return ErrNotCancellable
+	}
+
+	job.cancel()
+
+	if queue.Remove(job.ID) {
+		job.fail(job.ctx.Err())
+		job.closeLog()
+		job.removeUpload()
+	}
+
+	return nil
+}
+
+/*
+fail marks the job as cancelled if it was cancelled, as timed out if it ran out
+of time and as errored otherwise
+*/
+func (job *Job) fail(err error) {
+	switch job.ctx.Err() {
+	case context.Canceled:
+		job.Logger.Warn("job cancelled")
+		job.finish(StatusCancelled, "job was cancelled")
+		return
+	case context.DeadlineExceeded:
+		job.Logger.WithField("timeout", job.Timeout).Warn("job timed out")
+		job.finish(StatusTimedOut, "job timed out after "+job.Timeout)
+		return
+	}
+
+	job.Logger.WithField("error", err).Error("unable to process job synchronously")
+	job.errored(err)
+}
+
+// removeUpload removes the uploaded build context of a job that won't be built
+func (job *Job) removeUpload() {
+	if job.contextDir != "" {
+		fileutils.RmRF(job.contextDir)
+	}
+}
+
+func (job *Job) closeLog() {
+	if job.logFile != nil {
+		job.logFile.Close()
+	}
+}
+
+func (job *Job) errored(err error) {
+	job.update(func() {
+		job.Status = StatusErrored
+		job.Error = err.Error()
+	})
+}
+
+func (job *Job) save() {
+	if err := store.Save(job); err != nil && job.Logger != nil {
+		job.Logger.WithField("error", err).Error("error saving job")
+	}
+}
+
+// finished indicates whether or not the job has reached a terminal status
+func (job *Job) finished() bool {
+	job.lock.RLock()
+	defer job.lock.RUnlock()
+
+	return finishedStatus(job.Status)
+}
+
+func finishedStatus(status string) bool {
+	return status == StatusCompleted || status == StatusErrored ||
+		status == StatusCancelled || status == StatusTimedOut ||
+		status == StatusSuperseded
+}
+
 func (job *Job) processTestMode() error {
 	// If this function is used correctly,
 	// we should never see this warning message.
 	job.Logger.Warn("processing job in test mode")
 
 	// set status to validating in anticipation of performing validation step
-	job.Status = "validating"
+	job.setStatus(StatusValidating)
 
 	// set clone path to fixtures dir
 	job.clonedRepoLocation = specFixturesRepoDir
@@ -326,9 +582,18 @@ func (job *Job) processTestMode() error {
 	job.Logger.Debug("FOO")
 	job.Logger.Level = levelBefore
 
+	// report the same events as a build of a Bobfile without any containers
+	events := make(comm.EventChan, 2)
+	reporter := comm.NewReporter(nil, events)
+	reporter.Event(comm.EventOptions{EventType: comm.RequestedEvent})
+	reporter.Event(comm.EventOptions{EventType: comm.CompletedEvent})
+	close(events)
+	for e := range events {
+		job.recordEvent(e)
+	}
+
 	// mark job as completed
-	job.Status = "completed"
-	job.Completed = time.Now()
+	job.finish(StatusCompleted, "")
 
 	return nil
 }
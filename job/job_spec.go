@@ -3,6 +3,12 @@ package job
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 /*
@@ -13,13 +19,40 @@ type Spec struct {
 	RepoOwner      string `json:"account"`
 	RepoName       string `json:"repo"`
 	GitRef         string `json:"ref"`
+	Branch         string `json:"branch"`
 	GitHubAPIToken string `json:"api_token"`
 	Depth          string `json:"depth"`
+	CloneURL       string `json:"clone_url"`
+	Timeout        string `json:"timeout"`
+	Submodules     bool   `json:"submodules"`
+	Recursive      bool   `json:"recursive"`
 	Sync           bool   `json:"sync"`
+
+	// Upload is an uploaded tar archive of the build context, which is
+	// extracted into ContextDir and built instead of cloning the repo
+	Upload     io.Reader `json:"-"`
+	ContextDir string    `json:"-"`
+
+	// Trigger is set by whatever received the job (one of the Trigger*
+	// constants) rather than parsed from the request
+	Trigger string `json:"-"`
+
+	// RetryOf is the id of the job that this spec retries, if any
+	RetryOf string `json:"-"`
+
+	// TokenName is the name of the API token the job was requested with, if
+	// any, which is set by the server rather than parsed from the request
+	TokenName string `json:"-"`
+
+	// Submitter is who the request was authenticated as, if anyone, which is
+	// also set by the server
+	Submitter string `json:"-"`
 }
 
 /*
-NewSpec creates a new job spec from raw json data
+NewSpec creates a new job spec from raw json data.  If a clone URL is given,
+the account and repo default to the last two elements of its path.  recursive
+is the same as submodules (it's what kamino calls it).
 */
 func NewSpec(raw []byte) (*Spec, error) {
 	var ret = &Spec{}
@@ -27,13 +60,54 @@ func NewSpec(raw []byte) (*Spec, error) {
 		return nil, err
 	}
 
+	ret.Submodules = ret.Submodules || ret.Recursive
+
+	if ret.CloneURL != "" {
+		account, repo := repoFromRemote(ret.CloneURL)
+		if ret.RepoOwner == "" {
+			ret.RepoOwner = account
+		}
+		if ret.RepoName == "" {
+			ret.RepoName = repo
+		}
+	}
+
 	return ret, nil
 }
 
+/*
+ClonedRepo is the account and repo that the job's repo is cloned from.  With a
+clone URL they're guessed from the URL, since the spec's account and repo may
+be anything.
+*/
+func (spec *Spec) ClonedRepo() (account, repo string) {
+	if spec.CloneURL == "" {
+		return spec.RepoOwner, spec.RepoName
+	}
+	return repoFromRemote(spec.CloneURL)
+}
+
 /*
 Validate checks that required fields are present in the spec.
 */
 func (spec *Spec) Validate() error {
+	if spec.Timeout != "" {
+		if timeout, err := time.ParseDuration(spec.Timeout); err != nil || timeout <= 0 {
+			return fmt.Errorf("%q is not a valid timeout", spec.Timeout)
+		}
+	}
+
+	// the account and repo are used in paths under the workdir and clone cache
+	for field, name := range map[string]string{"account": spec.RepoOwner, "repo": spec.RepoName} {
+		if name != "" && !validPathName(name) {
+			return fmt.Errorf("%q is not a valid %s", name, field)
+		}
+	}
+
+	// an uploaded build context doesn't need to be cloned from anywhere
+	if spec.Upload != nil || spec.ContextDir != "" {
+		return nil
+	}
 
 	if spec.RepoOwner == "" {
 		return errors.New("account must be provided for job spec")
@@ -47,5 +121,42 @@ func (spec *Spec) Validate() error {
 		return errors.New("ref must be provided for job spec")
 	}
 
+	// the ref is given to git checkout, which would take it for an option
+	if strings.HasPrefix(spec.GitRef, "-") {
+		return fmt.Errorf("%q is not a valid ref", spec.GitRef)
+	}
+
+	if spec.Depth != "" {
+		if depth, err := strconv.Atoi(spec.Depth); err != nil || depth < 1 {
+			return fmt.Errorf("%q is not a valid clone depth", spec.Depth)
+		}
+	}
+
+	if spec.CloneURL != "" {
+		if _, _, err := parseRemote(spec.CloneURL); err != nil {
+			return err
+		}
+		// the clone url shows up in the job, so it mustn't include secrets
+		if parsed, err := url.Parse(spec.CloneURL); err == nil && parsed.User != nil {
+			if _, hasPassword := parsed.User.Password(); hasPassword {
+				return errors.New("clone_url must not include a password, configure credentials for the host instead")
+			}
+		}
+	}
+
 	return nil
 }
+
+/*
+validPathName is whether name is safe to use as (part of) a path, which it is
+if none of its elements are empty, "." or "..".  GitLab's nested groups mean an
+account may have several elements, e.g. "group/subgroup".
+*/
+func validPathName(name string) bool {
+	for _, element := range strings.Split(name, "/") {
+		if element == "" || element == "." || element == ".." {
+			return false
+		}
+	}
+	return true
+}
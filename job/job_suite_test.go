@@ -34,8 +34,12 @@ func init() {
 
 	// job control routes
 	testServer.Group("/jobs", func(r martini.Router) {
-		r.Get("/:id", Get)
-		r.Get("/:id/tail", TailN)
+		r.Get("/:id", CheckAccess, Get)
+		r.Delete("/:id", CheckAccess, Cancel)
+		r.Get("/:id/tail", CheckAccess, TailN)
+		r.Get("/:id/stream", CheckAccess, Stream)
+		r.Get("/:id/events", CheckAccess, GetEvents)
+		r.Post("/:id/retry", CheckAccess, webhook.Retry)
 		r.Post("", webhook.DockerBuild)
 		r.Get("", GetAll)
 	})
@@ -1,10 +1,13 @@
 package job_test
 
 import (
+	"archive/tar"
 	"bytes"
 	"encoding/json"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"time"
 
 	"github.com/modcloth/go-fileutils"
 
@@ -13,6 +16,7 @@ import (
 	. "github.com/rafecolton/docker-builder/job"
 
 	"github.com/rafecolton/docker-builder/server/webhook"
+	"github.com/rafecolton/docker-builder/tokens"
 )
 
 type logMessage struct {
@@ -37,7 +41,7 @@ var (
 	data        = []byte(validBody)
 	recorder2   *httptest.ResponseRecorder
 	job         = &Job{}
-	jobMap      = []Job{}
+	jobMap      = []*Job{}
 	expectedJob = &Job{
 		Account:  "foo",
 		ID:       jobID,
@@ -69,6 +73,19 @@ var _ = Describe("POST /jobs", func() {
 	})
 })
 
+var _ = Describe("job metrics", func() {
+	It("counts jobs by repo and trigger as they're created and finish", func() {
+		created := JobsCreated("foo/bar", TriggerAPI)
+		completed := JobsFinished("foo/bar", TriggerAPI, StatusCompleted)
+
+		post, _ := makeRequest("POST", "jobs", data)
+		testServer.ServeHTTP(httptest.NewRecorder(), post)
+
+		Expect(JobsCreated("foo/bar", TriggerAPI)).To(Equal(created + 1))
+		Expect(JobsFinished("foo/bar", TriggerAPI, StatusCompleted)).To(Equal(completed + 1))
+	})
+})
+
 var _ = Describe("GET /jobs", func() {
 
 	BeforeEach(func() {
@@ -93,6 +110,193 @@ var _ = Describe("GET /jobs", func() {
 	})
 })
 
+var _ = Describe("GET /jobs with a query", func() {
+	var (
+		original Store
+		base     = time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC)
+	)
+
+	list := func(query string) (*httptest.ResponseRecorder, []string) {
+		recorder := httptest.NewRecorder()
+		get, _ := makeRequest("GET", "jobs?"+query, nil)
+		testServer.ServeHTTP(recorder, get)
+
+		var jobs []*Job
+		json.Unmarshal(recorder.Body.Bytes(), &jobs)
+		ids := []string{}
+		for _, job := range jobs {
+			ids = append(ids, job.ID)
+		}
+		return recorder, ids
+	}
+
+	BeforeEach(func() {
+		original = CurrentStore()
+		testJobs := NewMemoryStore()
+		SetStore(testJobs)
+
+		for i, j := range []*Job{
+			{ID: "a", Account: "foo", Repo: "bar", Ref: "master", Status: StatusCompleted, Completed: base.Add(time.Hour)},
+			{ID: "b", Account: "foo", Repo: "bar", Ref: "release/1.0", Status: StatusErrored, Completed: base.Add(2 * time.Hour)},
+			{ID: "c", Account: "foo", Repo: "baz", Ref: "release/1.1", Status: StatusCancelled, Completed: base.Add(3 * time.Hour)},
+			{ID: "d", Account: "qux", Repo: "bar", Ref: "master", Status: StatusQueued},
+		} {
+			j.Created = base.Add(time.Duration(i) * time.Minute)
+			testJobs.Save(j)
+		}
+	})
+
+	AfterEach(func() {
+		SetStore(original)
+	})
+
+	It("lists the newest jobs first, with the total count", func() {
+		recorder, ids := list("")
+
+		Expect(recorder.Code).To(Equal(200))
+		Expect(ids).To(Equal([]string{"d", "c", "b", "a"}))
+		Expect(recorder.Header().Get("X-Total-Count")).To(Equal("4"))
+		Expect(recorder.Header().Get("Link")).To(BeEmpty())
+	})
+
+	It("matches any of the values of a filter", func() {
+		_, ids := list("status=errored,cancelled")
+		Expect(ids).To(Equal([]string{"c", "b"}))
+
+		_, ids = list("status=errored&status=queued&repo=bar")
+		Expect(ids).To(Equal([]string{"d", "b"}))
+	})
+
+	It("matches refs by prefix", func() {
+		_, ids := list("ref_prefix=release/")
+		Expect(ids).To(Equal([]string{"c", "b"}))
+	})
+
+	It("filters by created and completed times", func() {
+		_, ids := list("created_after=2016-01-01T12:00:00Z&created_before=2016-01-01T12:03:00Z")
+		Expect(ids).To(Equal([]string{"c", "b"}))
+
+		_, ids = list("completed_after=2016-01-01T13:30:00Z")
+		Expect(ids).To(Equal([]string{"c", "b"}))
+
+		_, ids = list("completed_before=2016-01-01T14:30:00Z")
+		Expect(ids).To(Equal([]string{"b", "a"}))
+	})
+
+	It("sorts by other fields", func() {
+		_, ids := list("sort=created")
+		Expect(ids).To(Equal([]string{"a", "b", "c", "d"}))
+
+		_, ids = list("sort=-completed")
+		Expect(ids).To(Equal([]string{"c", "b", "a", "d"}))
+
+		_, ids = list("sort=repo")
+		Expect(ids).To(Equal([]string{"a", "b", "d", "c"}))
+	})
+
+	It("pages through the jobs", func() {
+		recorder, ids := list("limit=2&sort=id")
+		Expect(ids).To(Equal([]string{"a", "b"}))
+		Expect(recorder.Header().Get("X-Total-Count")).To(Equal("4"))
+		Expect(recorder.Header().Get("Link")).To(Equal(`</jobs?limit=2&offset=2&sort=id>; rel="next"`))
+
+		recorder, ids = list("limit=2&offset=2&sort=id")
+		Expect(ids).To(Equal([]string{"c", "d"}))
+		Expect(recorder.Header().Get("Link")).To(Equal(`</jobs?limit=2&offset=0&sort=id>; rel="prev"`))
+
+		recorder, ids = list("offset=10")
+		Expect(recorder.Code).To(Equal(200))
+		Expect(ids).To(BeEmpty())
+		Expect(recorder.Header().Get("X-Total-Count")).To(Equal("4"))
+	})
+
+	It("rejects invalid queries", func() {
+		for _, query := range []string{
+			"limit=-1",
+			"offset=x",
+			"sort=submitter",
+			"created_after=yesterday",
+		} {
+			recorder, _ := list(query)
+			Expect(recorder.Code).To(Equal(400), query)
+		}
+	})
+})
+
+var _ = Describe("API tokens", func() {
+	var original Store
+
+	// as if the request had been authenticated with token
+	withToken := func(method, path string, body []byte, token *tokens.Token) *httptest.ResponseRecorder {
+		req, _ := makeRequest(method, path, body)
+		recorder := httptest.NewRecorder()
+		testServer.ServeHTTP(recorder, tokens.WithToken(req, token))
+		return recorder
+	}
+
+	BeforeEach(func() {
+		original = CurrentStore()
+		SetStore(NewMemoryStore())
+	})
+
+	AfterEach(func() {
+		SetStore(original)
+	})
+
+	It("records the name of the token a job was requested with", func() {
+		recorder := withToken("POST", "jobs", data, &tokens.Token{Name: "ci", Repos: []string{"foo/*"}})
+		Expect(recorder.Code).To(Equal(webhook.SyncSuccessCode))
+
+		var created Job
+		json.Unmarshal(recorder.Body.Bytes(), &created)
+		Expect(created.TokenName).To(Equal("ci"))
+		Expect(created.Submitter).To(Equal("ci"))
+		Expect(Find(created.ID).Snapshot().TokenName).To(Equal("ci"))
+	})
+
+	It("forbids jobs for repos the token is limited away from", func() {
+		recorder := withToken("POST", "jobs", data, &tokens.Token{Name: "ci", Repos: []string{"qux/*"}})
+		Expect(recorder.Code).To(Equal(403))
+		Expect(CurrentStore().All()).To(BeEmpty())
+	})
+
+	It("forbids clone urls of other repos, or of the server itself", func() {
+		limited := &tokens.Token{Name: "ci", Repos: []string{"foo/*"}}
+		for _, cloneURL := range []string{
+			"https://github.com/qux/bar.git",
+			"ssh://git@github.com/qux/bar.git",
+			"file:///srv/git/foo/bar.git",
+		} {
+			body := []byte(`{"account": "foo", "repo": "bar", "ref": "master", "clone_url": "` + cloneURL + `"}`)
+			recorder := withToken("POST", "jobs", body, limited)
+			Expect(recorder.Code).To(Equal(403), cloneURL)
+		}
+		Expect(CurrentStore().All()).To(BeEmpty())
+
+		body := []byte(`{"ref": "master", "clone_url": "https://gitlab.example.com/foo/bar.git", "sync": true}`)
+		Expect(withToken("POST", "jobs", body, limited).Code).To(Equal(webhook.SyncSuccessCode))
+	})
+
+	It("hides the jobs of other repos", func() {
+		post, _ := makeRequest("POST", "jobs", data)
+		testServer.ServeHTTP(httptest.NewRecorder(), post)
+
+		limited := &tokens.Token{Name: "ci", Repos: []string{"qux/*"}}
+
+		recorder := withToken("GET", "jobs", nil, limited)
+		Expect(recorder.Code).To(Equal(200))
+		Expect(recorder.Body.String()).To(Equal("[]"))
+		Expect(recorder.Header().Get("X-Total-Count")).To(Equal("0"))
+
+		Expect(withToken("GET", "jobs/"+jobID, nil, limited).Code).To(Equal(404))
+		Expect(withToken("DELETE", "jobs/"+jobID, nil, limited).Code).To(Equal(404))
+
+		allowed := &tokens.Token{Name: "ci", Repos: []string{"foo/bar"}}
+		Expect(withToken("GET", "jobs/"+jobID, nil, allowed).Code).To(Equal(200))
+		Expect(withToken("GET", "jobs", nil, allowed).Header().Get("X-Total-Count")).To(Equal("1"))
+	})
+})
+
 var _ = Describe("GET /jobs/:id", func() {
 	BeforeEach(func() {
 		recorder = httptest.NewRecorder()
@@ -139,3 +343,357 @@ var _ = Describe("GET /jobs/:id/tail?n=1", func() {
 		Expect(recorder2.Code).To(Equal(200))
 	})
 })
+
+var _ = Describe("DELETE /jobs/:id", func() {
+	BeforeEach(func() {
+		recorder = httptest.NewRecorder()
+		recorder2 = httptest.NewRecorder()
+		post, _ := makeRequest("POST", "jobs", data)
+		testServer.ServeHTTP(recorder, post)
+	})
+
+	It("refuses to cancel a job that has already finished", func() {
+		del, _ := makeRequest("DELETE", "jobs/"+jobID, nil)
+		testServer.ServeHTTP(recorder2, del)
+
+		Expect(recorder2.Code).To(Equal(409))
+	})
+
+	It("returns 404 for a job that does not exist", func() {
+		del, _ := makeRequest("DELETE", "jobs/does-not-exist", nil)
+		testServer.ServeHTTP(recorder2, del)
+
+		Expect(recorder2.Code).To(Equal(404))
+	})
+})
+
+var _ = Describe("POST /jobs/:id/retry", func() {
+	var original Store
+
+	BeforeEach(func() {
+		// keep the retried jobs away from the other specs
+		original = CurrentStore()
+		SetStore(NewMemoryStore())
+
+		recorder = httptest.NewRecorder()
+		recorder2 = httptest.NewRecorder()
+		post, _ := makeRequest("POST", "jobs", []byte(`{"account": "foo", "repo": "bar", "ref": "baz", "depth": "1", "sync": true}`))
+		testServer.ServeHTTP(recorder, post)
+	})
+
+	AfterEach(func() {
+		SetStore(original)
+	})
+
+	It("enqueues a new job from the spec of the original and links the two", func() {
+		// jobs only get unique ids outside of test mode
+		TestMode = false
+		retry, _ := makeRequest("POST", "jobs/"+jobID+"/retry", nil)
+		testServer.ServeHTTP(recorder2, retry)
+		TestMode = true
+
+		var retried = &Job{}
+		json.Unmarshal(recorder2.Body.Bytes(), retried)
+		defer Find(retried.ID).Cancel()
+
+		Expect(recorder2.Code).To(Equal(webhook.AsyncSuccessCode))
+		Expect(retried.ID).ToNot(Equal(jobID))
+		Expect(retried.RetryOf).To(Equal(jobID))
+		Expect(retried.Account).To(Equal("foo"))
+		Expect(retried.Repo).To(Equal("bar"))
+		Expect(retried.Ref).To(Equal("baz"))
+		Expect(retried.GitCloneDepth).To(Equal("1"))
+		Expect(retried.Status).To(Equal(StatusQueued))
+
+		Expect(Find(jobID).Snapshot().RetriedBy).To(Equal([]string{retried.ID}))
+	})
+
+	It("refuses to retry a job built from an uploaded build context", func() {
+		post, _ := makeRequest("POST", "jobs?account=foo&repo=bar&sync=true", buildContext())
+		post.Header.Set("Content-Type", "application/x-tar")
+		testServer.ServeHTTP(httptest.NewRecorder(), post)
+
+		retry, _ := makeRequest("POST", "jobs/"+jobID+"/retry", nil)
+		testServer.ServeHTTP(recorder2, retry)
+
+		Expect(recorder2.Code).To(Equal(409))
+	})
+
+	It("returns 404 for a job that does not exist", func() {
+		retry, _ := makeRequest("POST", "jobs/does-not-exist/retry", nil)
+		testServer.ServeHTTP(recorder2, retry)
+
+		Expect(recorder2.Code).To(Equal(404))
+	})
+})
+
+var _ = Describe("POST /jobs with deduplication", func() {
+	var (
+		original Store
+		queued   []string
+	)
+
+	// post enqueues an async job for spec with a unique id and returns the
+	// response code and job
+	post := func(spec string) (int, map[string]interface{}) {
+		TestMode = false
+		defer func() { TestMode = true }()
+
+		recorder := httptest.NewRecorder()
+		req, _ := makeRequest("POST", "jobs", []byte(spec))
+		testServer.ServeHTTP(recorder, req)
+
+		var ret = map[string]interface{}{}
+		json.Unmarshal(recorder.Body.Bytes(), &ret)
+		if id, ok := ret["id"].(string); ok {
+			queued = append(queued, id)
+		}
+		return recorder.Code, ret
+	}
+
+	BeforeEach(func() {
+		original = CurrentStore()
+		SetStore(NewMemoryStore())
+		queued = nil
+		webhook.DedupJobs(true)
+	})
+
+	AfterEach(func() {
+		for _, id := range queued {
+			Find(id).Cancel()
+		}
+		webhook.DedupJobs(false)
+		SetStore(original)
+	})
+
+	It("responds with the job already in flight for the same build", func() {
+		code, first := post(`{"account": "foo", "repo": "bar", "ref": "baz"}`)
+		Expect(code).To(Equal(webhook.AsyncSuccessCode))
+		Expect(first["deduplicated"]).To(BeNil())
+
+		code, second := post(`{"account": "foo", "repo": "bar", "ref": "baz", "bobfile": "Bobfile"}`)
+		Expect(code).To(Equal(200))
+		Expect(second["deduplicated"]).To(Equal(true))
+		Expect(second["id"]).To(Equal(first["id"]))
+	})
+
+	It("starts a new job for a different ref or Bobfile", func() {
+		_, first := post(`{"account": "foo", "repo": "bar", "ref": "baz"}`)
+
+		code, other := post(`{"account": "foo", "repo": "bar", "ref": "qux"}`)
+		Expect(code).To(Equal(webhook.AsyncSuccessCode))
+		Expect(other["id"]).ToNot(Equal(first["id"]))
+
+		code, other = post(`{"account": "foo", "repo": "bar", "ref": "baz", "bobfile": "Bobfile.other"}`)
+		Expect(code).To(Equal(webhook.AsyncSuccessCode))
+		Expect(other["id"]).ToNot(Equal(first["id"]))
+	})
+
+	It("matches a SHA against the commit a job's ref resolved to", func() {
+		const sha = "0123456789abcdef0123456789abcdef01234567"
+
+		_, first := post(`{"account": "foo", "repo": "bar", "ref": "baz"}`)
+		Find(first["id"].(string)).Commit = sha
+
+		code, second := post(`{"account": "foo", "repo": "bar", "ref": "` + sha + `"}`)
+		Expect(code).To(Equal(200))
+		Expect(second["deduplicated"]).To(Equal(true))
+		Expect(second["id"]).To(Equal(first["id"]))
+
+		// baz may have moved on since the first job was cloned
+		code, other := post(`{"account": "foo", "repo": "bar", "ref": "baz"}`)
+		Expect(code).To(Equal(webhook.AsyncSuccessCode))
+		Expect(other["id"]).ToNot(Equal(first["id"]))
+	})
+
+	It("doesn't tell a sync request that the job in flight is done", func() {
+		_, first := post(`{"account": "foo", "repo": "bar", "ref": "baz"}`)
+
+		code, second := post(`{"account": "foo", "repo": "bar", "ref": "baz", "sync": true}`)
+		Expect(code).To(Equal(webhook.AsyncSuccessCode))
+		Expect(second["deduplicated"]).To(Equal(true))
+		Expect(second["id"]).To(Equal(first["id"]))
+		Expect(second["status"]).To(Equal(StatusQueued))
+	})
+
+	It("starts a new job once the previous one has finished", func() {
+		recorder := httptest.NewRecorder()
+		req, _ := makeRequest("POST", "jobs", data)
+		testServer.ServeHTTP(recorder, req)
+		Expect(recorder.Code).To(Equal(webhook.SyncSuccessCode))
+
+		code, other := post(`{"account": "foo", "repo": "bar", "ref": "baz"}`)
+		Expect(code).To(Equal(webhook.AsyncSuccessCode))
+		Expect(other["id"]).ToNot(Equal(jobID))
+	})
+
+	It("starts a new job every time unless the server deduplicates", func() {
+		webhook.DedupJobs(false)
+
+		_, first := post(`{"account": "foo", "repo": "bar", "ref": "baz"}`)
+		code, second := post(`{"account": "foo", "repo": "bar", "ref": "baz"}`)
+		Expect(code).To(Equal(webhook.AsyncSuccessCode))
+		Expect(second["id"]).ToNot(Equal(first["id"]))
+	})
+})
+
+var _ = Describe("GET /jobs/:id/stream", func() {
+	BeforeEach(func() {
+		recorder = httptest.NewRecorder()
+		recorder2 = httptest.NewRecorder()
+		post, _ := makeRequest("POST", "jobs", data)
+		testServer.ServeHTTP(recorder, post)
+	})
+
+	It("sends the log followed by the final status of a finished job", func() {
+		get, _ := makeRequest("GET", "jobs/"+jobID+"/stream", nil)
+		testServer.ServeHTTP(recorder2, get)
+		body := recorder2.Body.String()
+
+		Expect(recorder2.Code).To(Equal(200))
+		Expect(recorder2.Header().Get("Content-Type")).To(Equal("text/event-stream"))
+		Expect(body).To(ContainSubstring("event: log\n"))
+		Expect(body).To(ContainSubstring(`"msg":"FOO"`))
+		Expect(body).To(HaveSuffix("\n\n"))
+		Expect(body).To(MatchRegexp(`event: status\ndata: \{.*"status":"completed".*\}\n\n$`))
+	})
+
+	It("resumes from the given offset", func() {
+		get, _ := makeRequest("GET", "jobs/"+jobID+"/stream?offset=100000", nil)
+		testServer.ServeHTTP(recorder2, get)
+
+		Expect(recorder2.Code).To(Equal(200))
+		Expect(recorder2.Body.String()).ToNot(ContainSubstring("event: log"))
+		Expect(recorder2.Body.String()).To(ContainSubstring("event: status"))
+	})
+
+	It("rejects an invalid offset", func() {
+		get, _ := makeRequest("GET", "jobs/"+jobID+"/stream?offset=foo", nil)
+		testServer.ServeHTTP(recorder2, get)
+
+		Expect(recorder2.Code).To(Equal(400))
+	})
+})
+
+var _ = Describe("GET /jobs/:id/events", func() {
+	BeforeEach(func() {
+		recorder = httptest.NewRecorder()
+		recorder2 = httptest.NewRecorder()
+		post, _ := makeRequest("POST", "jobs", data)
+		testServer.ServeHTTP(recorder, post)
+	})
+
+	It("receives the events recorded for the job", func() {
+		var events = []Event{}
+
+		get, _ := makeRequest("GET", "jobs/"+jobID+"/events", nil)
+		testServer.ServeHTTP(recorder2, get)
+		json.Unmarshal(recorder2.Body.Bytes(), &events)
+
+		Expect(recorder2.Code).To(Equal(200))
+		Expect(events).To(HaveLen(2))
+		Expect(events[0].Type).To(Equal("RequestedEvent"))
+		Expect(events[0].Time.IsZero()).To(BeFalse())
+		Expect(events[1].Type).To(Equal("CompletedEvent"))
+	})
+
+	It("returns 404 for a job that does not exist", func() {
+		get, _ := makeRequest("GET", "jobs/does-not-exist/events", nil)
+		testServer.ServeHTTP(recorder2, get)
+
+		Expect(recorder2.Code).To(Equal(404))
+	})
+})
+
+func buildContext() []byte {
+	buff := &bytes.Buffer{}
+	tw := tar.NewWriter(buff)
+	bobfile := []byte("[docker]\n")
+	tw.WriteHeader(&tar.Header{Name: "Bobfile", Mode: 0644, Size: int64(len(bobfile))})
+	tw.Write(bobfile)
+	tw.Close()
+	return buff.Bytes()
+}
+
+var _ = Describe("POST /jobs with an uploaded build context", func() {
+	BeforeEach(func() {
+		recorder = httptest.NewRecorder()
+	})
+
+	It("builds a multipart upload and records the submitter", func() {
+		webhook.BasicAuth("alice", "secret")
+		defer webhook.BasicAuth("", "")
+
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("spec", `{"bobfile": "Bobfile", "account": "foo", "repo": "bar", "sync": true}`)
+		part, _ := writer.CreateFormFile("context", "context.tar")
+		part.Write(buildContext())
+		writer.Close()
+
+		post, _ := makeRequest("POST", "jobs", body.Bytes())
+		post.Header.Set("Content-Type", writer.FormDataContentType())
+		post.SetBasicAuth("alice", "secret")
+		testServer.ServeHTTP(recorder, post)
+
+		var uploaded = &Job{}
+		json.Unmarshal(recorder.Body.Bytes(), uploaded)
+
+		Expect(recorder.Code).To(Equal(webhook.SyncSuccessCode))
+		Expect(uploaded.Uploaded).To(BeTrue())
+		Expect(uploaded.Submitter).To(Equal("alice"))
+		Expect(uploaded.Account).To(Equal("foo"))
+	})
+
+	It("builds a tar upload with the spec given as query parameters", func() {
+		post, _ := makeRequest("POST", "jobs?account=foo&repo=bar&sync=true", buildContext())
+		post.Header.Set("Content-Type", "application/x-tar")
+		testServer.ServeHTTP(recorder, post)
+
+		var uploaded = &Job{}
+		json.Unmarshal(recorder.Body.Bytes(), uploaded)
+
+		Expect(recorder.Code).To(Equal(webhook.SyncSuccessCode))
+		Expect(uploaded.Uploaded).To(BeTrue())
+		Expect(uploaded.Account).To(Equal("foo"))
+	})
+
+	It("only records a submitter the request was authenticated as", func() {
+		webhook.BasicAuth("alice", "secret")
+		defer webhook.BasicAuth("", "")
+
+		post, _ := makeRequest("POST", "jobs?account=foo&repo=bar&submitter=bob&sync=true", buildContext())
+		post.Header.Set("Content-Type", "application/x-tar")
+		post.SetBasicAuth("alice", "wrong")
+		testServer.ServeHTTP(recorder, post)
+
+		var uploaded = &Job{}
+		json.Unmarshal(recorder.Body.Bytes(), uploaded)
+
+		Expect(recorder.Code).To(Equal(webhook.SyncSuccessCode))
+		Expect(uploaded.Submitter).To(BeEmpty())
+
+		recorder = httptest.NewRecorder()
+		post, _ = makeRequest("POST", "jobs", []byte(`{"account": "foo", "repo": "bar", "ref": "baz", "submitter": "bob", "sync": true}`))
+		testServer.ServeHTTP(recorder, post)
+
+		var created = &Job{}
+		json.Unmarshal(recorder.Body.Bytes(), created)
+
+		Expect(recorder.Code).To(Equal(webhook.SyncSuccessCode))
+		Expect(created.Submitter).To(BeEmpty())
+	})
+
+	It("rejects an upload without a build context", func() {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("spec", `{"account": "foo", "repo": "bar"}`)
+		writer.Close()
+
+		post, _ := makeRequest("POST", "jobs", body.Bytes())
+		post.Header.Set("Content-Type", writer.FormDataContentType())
+		testServer.ServeHTTP(recorder, post)
+
+		Expect(recorder.Code).To(Equal(400))
+	})
+})
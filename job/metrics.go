@@ -0,0 +1,57 @@
+package job
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/rafecolton/docker-builder/metrics"
+)
+
+// The steps of a job whose durations are measured
+const (
+	StepClone = "clone"
+	StepBuild = "build"
+	StepTag   = "tag"
+	StepPush  = "push"
+)
+
+var (
+	jobsCreated = metrics.NewCounter(
+		"docker_builder_jobs_created_total",
+		"Jobs created, by repo and trigger.",
+		"repo", "trigger",
+	)
+
+	jobsFinished = metrics.NewCounter(
+		"docker_builder_jobs_finished_total",
+		"Jobs finished, by repo, trigger and final status.",
+		"repo", "trigger", "status",
+	)
+
+	stepDuration = metrics.NewHistogram(
+		"docker_builder_step_duration_seconds",
+		"How long the clone, build, tag and push steps of jobs take.",
+		metrics.DurationBuckets,
+		"step",
+	)
+
+	// the number of jobs being processed right now
+	inFlight int64
+
+	_ = metrics.NewGaugeFunc(
+		"docker_builder_jobs_in_flight",
+		"Jobs being processed (cloned, built, tagged or pushed) right now.",
+		func() float64 { return float64(atomic.LoadInt64(&inFlight)) },
+	)
+
+	_ = metrics.NewGaugeFunc(
+		"docker_builder_queue_depth",
+		"Async jobs waiting in the queue.",
+		func() float64 { return float64(queue.Len()) },
+	)
+)
+
+// observeStep records how long a step that started at start took
+func observeStep(step string, start time.Time) {
+	stepDuration.Observe(time.Since(start).Seconds(), step)
+}
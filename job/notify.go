@@ -0,0 +1,187 @@
+package job
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// DefaultNotifyAttempts is the number of times delivery of a notification
+	// is attempted before giving up on it
+	DefaultNotifyAttempts = 5
+
+	// DefaultNotifyBackoff is how long to wait before retrying a failed
+	// notification.  The wait doubles after each failed attempt.
+	DefaultNotifyBackoff = time.Second
+
+	// notifyBacklog is the number of notifications that may be waiting for
+	// delivery to a single URL before new ones are dropped
+	notifyBacklog = 100
+)
+
+// notifier is the (global) notifier used for job status changes
+var notifier *Notifier
+var notifierLock sync.RWMutex
+
+/*
+Notifier POSTs the job as JSON to each of its URLs whenever the job's status
+changes.  Notifications are delivered in order for each URL, are signed with
+an HMAC of the body if a secret is configured, and are retried with
+exponential backoff when a URL can't be reached or responds with anything
+other than a 2xx.
+
+The signature is sent in the X-Docker-Builder-Signature header as
+"sha256=<hex digest>", and the status that triggered the notification in the
+X-Docker-Builder-Status header.
+*/
+type Notifier struct {
+	URLs     []string
+	Secret   string
+	Attempts int
+	Backoff  time.Duration
+	Client   *http.Client
+	Logger   *logrus.Logger
+
+	deliveries map[string]chan *notification
+}
+
+type notification struct {
+	status string
+	body   []byte
+}
+
+/*
+SetNotifier sets the notifier used for job status changes and starts
+delivering notifications to its URLs.  A nil notifier turns notifications off.
+*/
+func SetNotifier(n *Notifier) {
+	if n != nil {
+		n.start()
+	}
+
+	notifierLock.Lock()
+	defer notifierLock.Unlock()
+	notifier = n
+}
+
+func (n *Notifier) start() {
+	if n.Attempts < 1 {
+		n.Attempts = DefaultNotifyAttempts
+	}
+	if n.Backoff == 0 {
+		n.Backoff = DefaultNotifyBackoff
+	}
+	if n.Client == nil {
+		n.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+	if n.Logger == nil {
+		n.Logger = &logrus.Logger{Out: ioutil.Discard, Formatter: &logrus.TextFormatter{}}
+	}
+
+	n.deliveries = map[string]chan *notification{}
+	for _, url := range n.URLs {
+		deliveries := make(chan *notification, notifyBacklog)
+		n.deliveries[url] = deliveries
+		go n.deliver(url, deliveries)
+	}
+}
+
+// Notify queues a notification of the job's current status for each URL
+func (n *Notifier) Notify(job *Job) {
+	snapshot := job.Snapshot()
+
+	body, err := json.Marshal(snapshot)
+	if err != nil {
+		n.Logger.WithField("error", err).Error("unable to marshal job for notification")
+		return
+	}
+
+	note := &notification{status: snapshot.Status, body: body}
+	for url, deliveries := range n.deliveries {
+		select {
+		case deliveries <- note:
+		default:
+			n.Logger.WithFields(logrus.Fields{
+				"url":    url,
+				"job_id": snapshot.ID,
+				"status": snapshot.Status,
+			}).Warn("too many pending notifications, dropping notification")
+		}
+	}
+}
+
+func (n *Notifier) deliver(url string, deliveries chan *notification) {
+	for note := range deliveries {
+		backoff := n.Backoff
+		for attempt := 1; ; attempt++ {
+			err := n.post(url, note)
+			if err == nil {
+				break
+			}
+
+			fields := logrus.Fields{"url": url, "status": note.status, "attempt": attempt, "error": err}
+			if attempt >= n.Attempts {
+				n.Logger.WithFields(fields).Error("giving up on notification")
+				break
+			}
+
+			n.Logger.WithFields(fields).Warn("notification failed, retrying")
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+func (n *Notifier) post(url string, note *notification) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(note.body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Docker-Builder-Status", note.status)
+	if n.Secret != "" {
+		req.Header.Set("X-Docker-Builder-Signature", "sha256="+Sign(n.Secret, note.body))
+	}
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body using secret as the key
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func notify(job *Job) {
+	notifierLock.RLock()
+	n := notifier
+	notifierLock.RUnlock()
+
+	if n != nil {
+		n.Notify(job)
+	}
+}
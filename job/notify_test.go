@@ -0,0 +1,70 @@
+package job_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rafecolton/docker-builder/job"
+)
+
+var _ = Describe("job notifications", func() {
+	const secret = "s3cr3t"
+
+	var (
+		receiver   *httptest.Server
+		lock       sync.Mutex
+		statuses   []string
+		signatures []bool
+		attempts   int
+	)
+
+	received := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string{}, statuses...)
+	}
+
+	BeforeEach(func() {
+		statuses, signatures, attempts = []string{}, []bool{}, 0
+		receiver = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			// fail the first attempt so that it has to be retried
+			if attempts++; attempts == 1 {
+				w.WriteHeader(500)
+				return
+			}
+
+			body, _ := ioutil.ReadAll(req.Body)
+			var j Job
+			json.Unmarshal(body, &j)
+			Expect(req.Header.Get("X-Docker-Builder-Status")).To(Equal(j.Status))
+
+			statuses = append(statuses, j.Status)
+			signatures = append(signatures, req.Header.Get("X-Docker-Builder-Signature") == "sha256="+Sign(secret, body))
+		}))
+		SetNotifier(&Notifier{URLs: []string{receiver.URL}, Secret: secret, Backoff: time.Millisecond})
+	})
+
+	AfterEach(func() {
+		SetNotifier(nil)
+		receiver.Close()
+	})
+
+	It("posts the signed job to each URL whenever its status changes", func() {
+		recorder = httptest.NewRecorder()
+		post, _ := makeRequest("POST", "jobs", data)
+		testServer.ServeHTTP(recorder, post)
+		Expect(recorder.Code).To(Equal(201))
+
+		Eventually(received).Should(Equal([]string{StatusCreated, StatusValidating, StatusCompleted}))
+		Expect(signatures).To(Equal([]bool{true, true, true}))
+	})
+})
@@ -0,0 +1,198 @@
+package job
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jobFilters are the fields jobs may be filtered by, each with its value
+var jobFilters = map[string]func(*Job) string{
+	"account": func(job *Job) string { return job.Account },
+	"bobfile": func(job *Job) string { return job.Bobfile },
+	"branch":  func(job *Job) string { return job.Branch },
+	"id":      func(job *Job) string { return job.ID },
+	"ref":     func(job *Job) string { return job.Ref },
+	"repo":    func(job *Job) string { return job.Repo },
+	"status":  func(job *Job) string { return job.Status },
+	"trigger": func(job *Job) string { return job.Trigger },
+}
+
+/*
+jobQuery is the query string of GET /jobs: which jobs to list, in which order,
+and which page of them.  A zero time means no bound, and a zero limit means no
+limit.
+*/
+type jobQuery struct {
+	filters         map[string][]string
+	refPrefix       string
+	createdAfter    time.Time
+	createdBefore   time.Time
+	completedAfter  time.Time
+	completedBefore time.Time
+	sort            string
+	descending      bool
+	limit           int
+	offset          int
+}
+
+/*
+parseJobQuery parses the query string of GET /jobs.  A filter matches any of
+its values, which may be given more than once or separated by commas (e.g.
+status=errored,cancelled).  Unknown parameters are ignored.
+*/
+func parseJobQuery(values url.Values) (*jobQuery, error) {
+	q := &jobQuery{filters: map[string][]string{}}
+
+	for name, vals := range values {
+		if _, ok := jobFilters[name]; !ok {
+			continue
+		}
+		for _, val := range vals {
+			for _, v := range strings.Split(val, ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					q.filters[name] = append(q.filters[name], v)
+				}
+			}
+		}
+	}
+
+	q.refPrefix = values.Get("ref_prefix")
+
+	for name, t := range map[string]*time.Time{
+		"created_after":    &q.createdAfter,
+		"created_before":   &q.createdBefore,
+		"completed_after":  &q.completedAfter,
+		"completed_before": &q.completedBefore,
+	} {
+		val := values.Get(name)
+		if val == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an RFC 3339 time, e.g. 2006-01-02T15:04:05Z", name)
+		}
+		*t = parsed
+	}
+
+	sortBy := values.Get("sort")
+	if sortBy == "" {
+		sortBy = DefaultSort
+	}
+	var ok bool
+	if q.sort, q.descending, ok = parseSort(sortBy); !ok {
+		return nil, fmt.Errorf("jobs can't be sorted by %q", q.sort)
+	}
+
+	for name, n := range map[string]*int{"limit": &q.limit, "offset": &q.offset} {
+		val := values.Get(name)
+		if val == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(val)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("%s must be a number no less than 0", name)
+		}
+		*n = parsed
+	}
+
+	return q, nil
+}
+
+// matches indicates whether or not the job passes all of the query's filters
+func (q *jobQuery) matches(job *Job) bool {
+	for name, values := range q.filters {
+		if !contains(values, jobFilters[name](job)) {
+			return false
+		}
+	}
+
+	if !strings.HasPrefix(job.Ref, q.refPrefix) {
+		return false
+	}
+
+	if !q.createdAfter.IsZero() && !job.Created.After(q.createdAfter) {
+		return false
+	}
+	if !q.createdBefore.IsZero() && !job.Created.Before(q.createdBefore) {
+		return false
+	}
+
+	// jobs that haven't finished don't have a completed time to compare
+	if !q.completedAfter.IsZero() && (job.Completed.IsZero() || !job.Completed.After(q.completedAfter)) {
+		return false
+	}
+	if !q.completedBefore.IsZero() && (job.Completed.IsZero() || !job.Completed.Before(q.completedBefore)) {
+		return false
+	}
+
+	return true
+}
+
+/*
+run filters and sorts jobs, returning the requested page of them along with the
+total number of jobs that matched
+*/
+func (q *jobQuery) run(jobs []*Job) (page []*Job, total int) {
+	page = []*Job{}
+	for _, job := range jobs {
+		if q.matches(job) {
+			page = append(page, job)
+		}
+	}
+	total = len(page)
+
+	sortJobs(page, q.sort, q.descending)
+
+	if q.offset >= len(page) {
+		return []*Job{}, total
+	}
+	page = page[q.offset:]
+	if q.limit > 0 && q.limit < len(page) {
+		page = page[:q.limit]
+	}
+
+	return page, total
+}
+
+/*
+links are the Link header values for the pages before and after the one at
+q.offset, if there are any
+*/
+func (q *jobQuery) links(u *url.URL, total int) []string {
+	if q.limit == 0 {
+		return nil
+	}
+
+	link := func(offset int, rel string) string {
+		values := u.Query()
+		values.Set("offset", strconv.Itoa(offset))
+		values.Set("limit", strconv.Itoa(q.limit))
+		return fmt.Sprintf(`<%s?%s>; rel="%s"`, u.Path, values.Encode(), rel)
+	}
+
+	var ret []string
+	if q.offset+q.limit < total {
+		ret = append(ret, link(q.offset+q.limit, "next"))
+	}
+	if q.offset > 0 {
+		prev := q.offset - q.limit
+		if prev < 0 {
+			prev = 0
+		}
+		ret = append(ret, link(prev, "prev"))
+	}
+	return ret
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,293 @@
+package job
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultWorkers is the number of workers used when none is configured
+	DefaultWorkers = 2
+
+	// DefaultRepoConcurrency is the number of jobs for the same repo that are
+	// processed at once when no limit is configured
+	DefaultRepoConcurrency = 1
+)
+
+/*
+Queue is a FIFO queue of async jobs.  Jobs are handed out to a fixed number of
+workers in the order in which they were pushed so that a burst of requests
+does not start an unbounded number of clones and builds at once.  A job is
+skipped over while the limit of jobs for its repo are already being processed,
+so that builds of the same repo finish in the order in which they were pushed.
+*/
+type Queue struct {
+	cond      *sync.Cond
+	pending   []*Job
+	running   map[string]int
+	repoLimit int
+}
+
+var queue = NewQueue()
+
+// NewQueue returns an empty Queue with no workers
+func NewQueue() *Queue {
+	return &Queue{
+		cond:      sync.NewCond(&sync.Mutex{}),
+		running:   map[string]int{},
+		repoLimit: DefaultRepoConcurrency,
+	}
+}
+
+/*
+SetRepoConcurrency sets the number of jobs for the same account/repo that are
+processed at once from the (global) queue
+*/
+func SetRepoConcurrency(n int) {
+	queue.SetRepoLimit(n)
+}
+
+/*
+Enqueue marks the job as queued and adds it to the (global) queue, where it
+will be processed by the next available worker.
+*/
+func Enqueue(job *Job) {
+	queue.Push(job)
+}
+
+/*
+Run processes the job right away rather than adding it to the (global) queue,
+for sync jobs that the client is waiting for.  The job still counts towards
+its repo's limit of jobs that are processed at once, and waits for one of
+them to finish if the limit has been reached.
+*/
+func Run(job *Job) error {
+	return queue.Run(job)
+}
+
+// StartWorkers starts n workers for processing jobs on the (global) queue
+func StartWorkers(n int) {
+	queue.Start(n)
+}
+
+// SetRepoLimit sets the number of jobs for the same repo that are processed at once
+func (q *Queue) SetRepoLimit(n int) {
+	if n < 1 {
+		n = DefaultRepoConcurrency
+	}
+
+	q.cond.L.Lock()
+	q.repoLimit = n
+	q.cond.L.Unlock()
+
+	q.cond.Broadcast()
+}
+
+/*
+Push marks the job as queued and adds it to the back of the queue.  If the job
+is for a branch, any jobs for the same branch that are still waiting in the
+queue are superseded by it, since the job builds a newer commit.  A retry
+doesn't supersede anything, since it rebuilds a commit that may well be older
+than the ones waiting in the queue.
+*/
+func (q *Queue) Push(job *Job) {
+	job.setStatus(StatusQueued)
+
+	snapshot := job.Snapshot()
+	var superseded []*Job
+
+	q.cond.L.Lock()
+	if snapshot.Branch != "" && snapshot.RetryOf == "" {
+		var kept []*Job
+		for _, pending := range q.pending {
+			if pending.supersededBy(snapshot) {
+				superseded = append(superseded, pending)
+			} else {
+				kept = append(kept, pending)
+			}
+		}
+		q.pending = kept
+	}
+	q.pending = append(q.pending, job)
+	q.cond.L.Unlock()
+
+	q.cond.Signal()
+
+	for _, older := range superseded {
+		older.supersede(snapshot.ID)
+	}
+}
+
+// Position returns the 1-based position of the job in the queue, or 0 if the
+// job is not waiting in the queue
+func (q *Queue) Position(id string) int {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	for i, job := range q.pending {
+		if job.ID == id {
+			return i + 1
+		}
+	}
+
+	return 0
+}
+
+// Remove removes the job with the given id from the queue, returning whether
+// or not it was waiting in the queue
+func (q *Queue) Remove(id string) bool {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	for i, job := range q.pending {
+		if job.ID == id {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Len returns the number of jobs waiting in the queue
+func (q *Queue) Len() int {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	return len(q.pending)
+}
+
+// Start starts n workers, each of which processes one job at a time
+func (q *Queue) Start(n int) {
+	if n < 1 {
+		n = DefaultWorkers
+	}
+
+	for i := 0; i < n; i++ {
+		go q.work()
+	}
+}
+
+func (q *Queue) work() {
+	for {
+		job := q.next()
+		job.Process()
+		q.done(job)
+	}
+}
+
+/*
+Run processes the job without putting it in the queue or waiting for a worker,
+once its repo isn't at its limit of jobs being processed.  A job that is
+cancelled while it waits is processed straight away, which fails it.
+*/
+func (q *Queue) Run(job *Job) error {
+	if q.acquire(job) {
+		defer q.done(job)
+	}
+	return job.Process()
+}
+
+// acquire waits until the job's repo isn't at its limit of jobs being
+// processed and counts the job as one of them, unless the job is done first
+func (q *Queue) acquire(job *Job) bool {
+	key := job.repoKey()
+
+	if ctx := job.ctx; ctx != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				// with the lock held, so that the wakeup can't be missed
+				q.cond.L.Lock()
+				q.cond.Broadcast()
+				q.cond.L.Unlock()
+			case <-stop:
+			}
+		}()
+	}
+
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	for q.running[key] >= q.repoLimit {
+		if job.ctx != nil && job.ctx.Err() != nil {
+			return false
+		}
+		q.cond.Wait()
+	}
+
+	q.running[key]++
+	return true
+}
+
+/*
+next blocks until a job is available and removes it from the queue.  The job
+is the one closest to the front of the queue whose repo isn't already at its
+limit of jobs being processed.
+*/
+func (q *Queue) next() *Job {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	for {
+		for i, job := range q.pending {
+			key := job.repoKey()
+			if q.running[key] >= q.repoLimit {
+				continue
+			}
+
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			q.running[key]++
+			return job
+		}
+
+		q.cond.Wait()
+	}
+}
+
+// done records that the job has been processed, which may let another job for
+// the same repo be processed
+func (q *Queue) done(job *Job) {
+	key := job.repoKey()
+
+	q.cond.L.Lock()
+	q.running[key]--
+	if q.running[key] <= 0 {
+		delete(q.running, key)
+	}
+	q.cond.L.Unlock()
+
+	q.cond.Broadcast()
+}
+
+// repoKey identifies the repo the job builds, for the per-repo limit
+func (job *Job) repoKey() string {
+	return job.Account + "/" + job.Repo
+}
+
+// supersededBy indicates whether the (queued) job is for an older commit of
+// the branch that newer is for
+func (job *Job) supersededBy(newer *Job) bool {
+	return job.Account == newer.Account && job.Repo == newer.Repo &&
+		job.Branch == newer.Branch && job.Bobfile == newer.Bobfile
+}
+
+// supersede stops a job that was removed from the queue because the job with
+// the given id builds a newer commit of the same branch
+func (job *Job) supersede(id string) {
+	job.Logger.WithField("superseded_by", id).Warn("job superseded by a newer commit")
+
+	if job.cancel != nil {
+		job.cancel()
+	}
+	job.update(func() {
+		job.SupersededBy = id
+		job.Status = StatusSuperseded
+		job.Error = "superseded by job " + id
+		job.Completed = time.Now()
+	})
+	job.closeLog()
+	job.removeUpload()
+}
@@ -0,0 +1,159 @@
+package job_test
+
+import (
+	"io/ioutil"
+
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rafecolton/docker-builder/job"
+)
+
+var _ = Describe("Queue", func() {
+	var (
+		q    *Queue
+		jobs []*Job
+	)
+
+	newJob := func(id, repo, branch string) *Job {
+		return &Job{ID: id, Account: "foo", Repo: repo, Branch: branch, Logger: &logrus.Logger{
+			Out:       ioutil.Discard,
+			Formatter: &logrus.JSONFormatter{},
+			Level:     logrus.PanicLevel,
+		}}
+	}
+
+	BeforeEach(func() {
+		q = NewQueue()
+		jobs = []*Job{}
+		for _, id := range []string{"first", "second", "third"} {
+			j := newJob(id, id, "")
+			q.Push(j)
+			jobs = append(jobs, j)
+		}
+	})
+
+	It("marks pushed jobs as queued in FIFO order", func() {
+		Expect(q.Len()).To(Equal(3))
+		for i, j := range jobs {
+			Expect(j.Snapshot().Status).To(Equal(StatusQueued))
+			Expect(q.Position(j.ID)).To(Equal(i + 1))
+		}
+		Expect(q.Position("nope")).To(Equal(0))
+	})
+
+	It("removes jobs from the queue", func() {
+		Expect(q.Remove("second")).To(BeTrue())
+		Expect(q.Remove("second")).To(BeFalse())
+		Expect(q.Position("first")).To(Equal(1))
+		Expect(q.Position("third")).To(Equal(2))
+	})
+
+	It("processes every job once workers are started", func() {
+		q.Start(1)
+
+		Eventually(q.Len).Should(Equal(0))
+		for _, j := range jobs {
+			Eventually(func() string { return j.Snapshot().Status }).Should(Equal(StatusCompleted))
+			Expect(q.Position(j.ID)).To(Equal(0))
+		}
+	})
+
+	Context("with jobs for the same repo", func() {
+		BeforeEach(func() {
+			q = NewQueue()
+		})
+
+		It("processes one job per repo at a time by default", func() {
+			a1, a2, b1 := newJob("a1", "a", ""), newJob("a2", "a", ""), newJob("b1", "b", "")
+			q.Push(a1)
+			q.Push(a2)
+			q.Push(b1)
+
+			Expect(q.Next()).To(Equal(a1))
+			Expect(q.Next()).To(Equal(b1))
+
+			next := make(chan *Job)
+			go func() { next <- q.Next() }()
+			Consistently(next).ShouldNot(Receive())
+
+			q.Done(a1)
+			Eventually(next).Should(Receive(Equal(a2)))
+		})
+
+		It("processes more jobs per repo at once when the limit is raised", func() {
+			a1, a2 := newJob("a1", "a", ""), newJob("a2", "a", "")
+			q.SetRepoLimit(2)
+			q.Push(a1)
+			q.Push(a2)
+
+			Expect(q.Next()).To(Equal(a1))
+			Expect(q.Next()).To(Equal(a2))
+		})
+
+		It("makes jobs that are run right away wait for the limit too", func() {
+			a1, a2, b1 := newJob("a1", "a", ""), newJob("a2", "a", ""), newJob("b1", "b", "")
+			q.Push(a1)
+			Expect(q.Next()).To(Equal(a1))
+
+			Expect(q.Run(b1)).To(BeNil())
+			Expect(b1.Snapshot().Status).To(Equal(StatusCompleted))
+
+			ran := make(chan error)
+			go func() { ran <- q.Run(a2) }()
+			Consistently(ran).ShouldNot(Receive())
+
+			q.Done(a1)
+			Eventually(ran).Should(Receive(BeNil()))
+			Expect(a2.Snapshot().Status).To(Equal(StatusCompleted))
+
+			// the job's slot is given back once it's done
+			a3 := newJob("a3", "a", "")
+			q.Push(a3)
+			Expect(q.Next()).To(Equal(a3))
+		})
+
+		It("supersedes queued jobs for older commits of the same branch", func() {
+			older := newJob("older", "a", "master")
+			other := newJob("other", "a", "feature")
+			elsewhere := newJob("elsewhere", "b", "master")
+			newer := newJob("newer", "a", "master")
+			for _, j := range []*Job{older, other, elsewhere, newer} {
+				q.Push(j)
+			}
+
+			Expect(q.Len()).To(Equal(3))
+			Expect(q.Position("older")).To(Equal(0))
+			Expect(q.Position("newer")).To(Equal(3))
+
+			snapshot := older.Snapshot()
+			Expect(snapshot.Status).To(Equal(StatusSuperseded))
+			Expect(snapshot.SupersededBy).To(Equal("newer"))
+			Expect(snapshot.Completed.IsZero()).To(BeFalse())
+
+			for _, j := range []*Job{other, elsewhere, newer} {
+				Expect(j.Snapshot().Status).To(Equal(StatusQueued))
+			}
+		})
+
+		It("doesn't let a retry supersede jobs for newer commits", func() {
+			newer := newJob("newer", "a", "master")
+			retry := newJob("retry", "a", "master")
+			retry.RetryOf = "older"
+			q.Push(newer)
+			q.Push(retry)
+
+			Expect(q.Len()).To(Equal(2))
+			Expect(newer.Snapshot().Status).To(Equal(StatusQueued))
+		})
+
+		It("doesn't supersede jobs that aren't for a branch", func() {
+			first, second := newJob("first", "a", ""), newJob("second", "a", "")
+			q.Push(first)
+			q.Push(second)
+
+			Expect(q.Len()).To(Equal(2))
+		})
+	})
+})
@@ -0,0 +1,246 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rafecolton/docker-builder/conf"
+
+	"github.com/docker/go-units"
+	"github.com/modcloth/go-fileutils"
+	"github.com/modcloth/kamino"
+	"github.com/rafecolton/go-dockerclient-quick"
+)
+
+const (
+	// DefaultReadyMinFreeDisk is the free space (in bytes) the readiness
+	// check expects in each of the dirs jobs write to when no minimum is
+	// configured
+	DefaultReadyMinFreeDisk = 1 << 30
+
+	// ReadyTimeout is how long the readiness check waits for the Docker API
+	ReadyTimeout = 5 * time.Second
+)
+
+// The statuses of a readiness check
+const (
+	CheckOK     = "ok"
+	CheckFailed = "failed"
+)
+
+// The names of the readiness checks
+const (
+	CheckDocker      = "docker"
+	CheckGit         = "git"
+	CheckDisk        = "disk"
+	CheckCredentials = "registry_credentials"
+)
+
+var (
+	readyMinFreeDisk     int64 = DefaultReadyMinFreeDisk
+	readyMinFreeDiskLock sync.RWMutex
+
+	// pingDocker is swapped out by the specs, which don't have a Docker daemon
+	pingDocker = func(ctx context.Context) error {
+		client, err := dockerclient.NewDockerClient()
+		if err != nil {
+			return err
+		}
+		return client.Client().PingWithContext(ctx)
+	}
+)
+
+// Check is the result of one of the readiness checks
+type Check struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+/*
+Readiness is the result of all of the readiness checks.  The server is ready
+to build if every check is ok.
+*/
+type Readiness struct {
+	Ready  bool             `json:"ready"`
+	Checks map[string]Check `json:"checks"`
+}
+
+/*
+SetReadyMinFreeDisk sets the (global) free space, in bytes, the readiness
+check expects in the dirs jobs write to.  Zero (or less) means the default.
+*/
+func SetReadyMinFreeDisk(bytes int64) {
+	if bytes <= 0 {
+		bytes = DefaultReadyMinFreeDisk
+	}
+
+	readyMinFreeDiskLock.Lock()
+	defer readyMinFreeDiskLock.Unlock()
+
+	readyMinFreeDisk = bytes
+}
+
+func currentReadyMinFreeDisk() int64 {
+	readyMinFreeDiskLock.RLock()
+	defer readyMinFreeDiskLock.RUnlock()
+
+	return readyMinFreeDisk
+}
+
+/*
+CheckReady checks whether or not the server is able to build: the Docker API
+responds, git is on the PATH, there's enough free space for workdirs, logs and
+cached clones, and there are credentials for pushing to the registry.
+*/
+func CheckReady() *Readiness {
+	ret := &Readiness{Ready: true, Checks: map[string]Check{
+		CheckDocker:      checkDocker(),
+		CheckGit:         checkGit(),
+		CheckDisk:        checkDisk(readyDirs(), currentReadyMinFreeDisk()),
+		CheckCredentials: checkCredentials(),
+	}}
+
+	for _, check := range ret.Checks {
+		if check.Status != CheckOK {
+			ret.Ready = false
+		}
+	}
+
+	return ret
+}
+
+func okCheck(detail string) Check {
+	return Check{Status: CheckOK, Detail: detail}
+}
+
+func failedCheck(err error) Check {
+	return Check{Status: CheckFailed, Error: err.Error()}
+}
+
+func checkDocker() Check {
+	ctx, cancel := context.WithTimeout(context.Background(), ReadyTimeout)
+	defer cancel()
+
+	if err := pingDocker(ctx); err != nil {
+		return failedCheck(err)
+	}
+	return okCheck("")
+}
+
+func checkGit() Check {
+	git, err := fileutils.Which("git")
+	if err != nil {
+		return failedCheck(err)
+	}
+	return okCheck(git)
+}
+
+/*
+readyDirs are the dirs jobs write to: the temp dir (for workdirs), the data dir
+(for logs) and the clone cache dir, when they are configured
+*/
+func readyDirs() []string {
+	dirs := []string{os.TempDir()}
+	if logRoot != "" {
+		dirs = append(dirs, filepath.Dir(logRoot))
+	}
+	if dir, option := cloneCache(); dir != "" && option != kamino.No {
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// checkDisk fails if any of dirs has less than min bytes free
+func checkDisk(dirs []string, min int64) Check {
+	var details []string
+	seen := map[string]bool{}
+
+	for _, dir := range dirs {
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+
+		free, err := freeSpace(dir)
+		if err != nil {
+			return failedCheck(fmt.Errorf("unable to check free space in %s: %v", dir, err))
+		}
+		if free < min {
+			return failedCheck(fmt.Errorf("%s free in %s, need at least %s",
+				units.BytesSize(float64(free)), dir, units.BytesSize(float64(min))))
+		}
+		details = append(details, fmt.Sprintf("%s free in %s", units.BytesSize(float64(free)), dir))
+	}
+
+	sort.Strings(details)
+	return okCheck(strings.Join(details, ", "))
+}
+
+// freeSpace is the number of bytes available to unprivileged users in dir
+func freeSpace(dir string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
+
+/*
+checkCredentials passes if pushes are skipped, if the registry username and
+password are configured, or if there's a docker config file with credentials
+in the home dir.  Credentials given in a Bobfile can't be checked ahead of
+time.
+*/
+func checkCredentials() Check {
+	if SkipPush {
+		return okCheck("pushes are skipped")
+	}
+
+	un, pass := conf.Config.CfgUn, conf.Config.CfgPass
+	switch {
+	case un != "" && pass != "":
+		return okCheck("configured")
+	case un != "" || pass != "":
+		return failedCheck(fmt.Errorf("both a registry username and password are needed"))
+	}
+
+	if home := os.Getenv("HOME"); home != "" {
+		for _, path := range []string{
+			filepath.Join(home, ".docker", "config.json"),
+			filepath.Join(home, ".dockercfg"),
+		} {
+			if _, err := os.Stat(path); err == nil {
+				return okCheck(path)
+			}
+		}
+	}
+
+	return failedCheck(fmt.Errorf("no registry credentials are configured"))
+}
+
+/*
+Ready is the handler function for the readiness route.  It responds with the
+result of each check, and a 503 if any of them failed.
+*/
+func Ready() (int, string) {
+	readiness := CheckReady()
+
+	retBytes, err := json.Marshal(readiness)
+	if err != nil {
+		return 409, `{"error": "` + err.Error() + `"}`
+	}
+
+	if !readiness.Ready {
+		return 503, string(retBytes)
+	}
+	return 200, string(retBytes)
+}
@@ -0,0 +1,136 @@
+package job_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rafecolton/docker-builder/conf"
+	. "github.com/rafecolton/docker-builder/job"
+)
+
+var _ = Describe("readiness", func() {
+	var (
+		home, originalHome string
+		pingErr            error
+		restorePing        func()
+		originalConf       conf.Conf
+		originalSkipPush   bool
+	)
+
+	ready := func() (int, *Readiness) {
+		code, body := Ready()
+		readiness := &Readiness{}
+		Expect(json.Unmarshal([]byte(body), readiness)).To(BeNil())
+		return code, readiness
+	}
+
+	BeforeEach(func() {
+		pingErr = nil
+		restorePing = SetPingDocker(func(context.Context) error { return pingErr })
+
+		home, _ = ioutil.TempDir("", "ready")
+		originalHome = os.Getenv("HOME")
+		os.Setenv("HOME", home)
+
+		originalConf = conf.Config
+		originalSkipPush = SkipPush
+		SkipPush = true
+
+		// anything will do
+		SetReadyMinFreeDisk(1)
+	})
+
+	AfterEach(func() {
+		restorePing()
+		os.Setenv("HOME", originalHome)
+		os.RemoveAll(home)
+		conf.Config = originalConf
+		SkipPush = originalSkipPush
+		SetReadyMinFreeDisk(0)
+	})
+
+	It("responds with 200 when every check passes", func() {
+		code, readiness := ready()
+
+		Expect(code).To(Equal(200))
+		Expect(readiness.Ready).To(BeTrue())
+		for _, name := range []string{CheckDocker, CheckGit, CheckDisk, CheckCredentials} {
+			Expect(readiness.Checks[name].Status).To(Equal(CheckOK), name)
+		}
+		Expect(readiness.Checks[CheckGit].Detail).To(ContainSubstring("git"))
+		Expect(readiness.Checks[CheckDisk].Detail).To(ContainSubstring(os.TempDir()))
+	})
+
+	It("responds with 503 when the Docker API can't be reached", func() {
+		pingErr = errors.New("cannot connect to the Docker daemon")
+
+		code, readiness := ready()
+
+		Expect(code).To(Equal(503))
+		Expect(readiness.Ready).To(BeFalse())
+		Expect(readiness.Checks[CheckDocker]).To(Equal(Check{
+			Status: CheckFailed,
+			Error:  "cannot connect to the Docker daemon",
+		}))
+		Expect(readiness.Checks[CheckGit].Status).To(Equal(CheckOK))
+	})
+
+	It("fails when there isn't enough free space", func() {
+		SetReadyMinFreeDisk(1 << 62)
+
+		code, readiness := ready()
+
+		Expect(code).To(Equal(503))
+		Expect(readiness.Checks[CheckDisk].Status).To(Equal(CheckFailed))
+		Expect(readiness.Checks[CheckDisk].Error).To(ContainSubstring("need at least"))
+	})
+
+	Describe("registry credentials", func() {
+		BeforeEach(func() {
+			SkipPush = false
+			conf.Config.CfgUn = ""
+			conf.Config.CfgPass = ""
+		})
+
+		It("fails when there are none", func() {
+			code, readiness := ready()
+
+			Expect(code).To(Equal(503))
+			Expect(readiness.Checks[CheckCredentials].Status).To(Equal(CheckFailed))
+		})
+
+		It("fails when only the username is configured", func() {
+			conf.Config.CfgUn = "builder"
+
+			_, readiness := ready()
+
+			Expect(readiness.Checks[CheckCredentials].Status).To(Equal(CheckFailed))
+		})
+
+		It("passes when the username and password are configured", func() {
+			conf.Config.CfgUn = "builder"
+			conf.Config.CfgPass = "secret"
+
+			code, readiness := ready()
+
+			Expect(code).To(Equal(200))
+			Expect(readiness.Checks[CheckCredentials].Detail).To(Equal("configured"))
+		})
+
+		It("passes when there's a docker config file", func() {
+			os.MkdirAll(filepath.Join(home, ".docker"), 0755)
+			ioutil.WriteFile(filepath.Join(home, ".docker", "config.json"), []byte(`{"auths": {}}`), 0600)
+
+			code, readiness := ready()
+
+			Expect(code).To(Equal(200))
+			Expect(readiness.Checks[CheckCredentials].Status).To(Equal(CheckOK))
+		})
+	})
+})
@@ -0,0 +1,82 @@
+package job
+
+import (
+	"errors"
+)
+
+var (
+	// ErrNoSpec is returned when retrying a job whose spec wasn't kept, e.g.
+	// one recorded before specs were persisted
+	ErrNoSpec = errors.New("job spec was not kept, so the job can't be retried")
+
+	// ErrUploadNotKept is returned when retrying a job that was built from an
+	// uploaded build context, since the context is removed after the build
+	ErrUploadNotKept = errors.New("uploaded build contexts are not kept, so the job can't be retried")
+
+	// ErrNotFinished is returned when retrying a job that is still in progress
+	ErrNotFinished = errors.New("job has not finished yet")
+)
+
+/*
+storedSpec is the copy of spec that is kept with the job so that the job can be
+retried.  The upload (if any) has already been consumed by then.
+*/
+func storedSpec(spec *Spec) *Spec {
+	ret := *spec
+	ret.Upload = nil
+	ret.Sync = false
+	return &ret
+}
+
+/*
+RetrySpec returns a spec for a new job that builds the same account, repo, ref
+and Bobfile (with the same options) as the job did.  The new job is linked to
+the job through its RetryOf field once it's created with NewJob.  The submitter
+and token name are left blank so that whoever asked for the retry is recorded
+instead.
+*/
+func (job *Job) RetrySpec() (*Spec, error) {
+	snapshot := job.Snapshot()
+
+	if !job.finished() {
+		return nil, ErrNotFinished
+	}
+	if snapshot.Uploaded {
+		return nil, ErrUploadNotKept
+	}
+	if snapshot.spec == nil {
+		return nil, ErrNoSpec
+	}
+
+	ret := *snapshot.spec
+	ret.Submitter = ""
+	ret.TokenName = ""
+	ret.Trigger = snapshot.Trigger
+	ret.RetryOf = snapshot.ID
+	if ret.GitHubAPIToken == "" {
+		ret.GitHubAPIToken = snapshot.GitHubAPIToken
+	}
+
+	return &ret, nil
+}
+
+// linkRetry records the job in the RetriedBy field of the job it retries
+func (job *Job) linkRetry() {
+	if job.RetryOf == "" {
+		return
+	}
+
+	original := store.Get(job.RetryOf)
+	if original == nil {
+		return
+	}
+
+	original.update(func() {
+		original.RetriedBy = append(original.RetriedBy, job.ID)
+	})
+}
+
+// Find returns the job with the given id, or nil if there is no such job
+func Find(id string) *Job {
+	return store.Get(id)
+}
@@ -0,0 +1,266 @@
+package job
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const githubHost = "github.com"
+
+// scpLikeRegex matches scp-like SSH remotes, e.g. git@example.com:foo/bar.git
+var scpLikeRegex = regexp.MustCompile(`^(?:([^@/]+)@)?([^:/]+):(.+)$`)
+
+// the schemes that may be used in a clone URL
+var remoteSchemes = map[string]bool{
+	"file":  true,
+	"git":   true,
+	"http":  true,
+	"https": true,
+	"ssh":   true,
+}
+
+var (
+	credentials     []Credential
+	credentialsLock sync.RWMutex
+)
+
+/*
+Credential is used when cloning from the git host Host.  For HTTP(S) remotes,
+Username and Password are sent with the clone, and for SSH remotes, SSHKey is
+the path to a private key (e.g. a deploy key) and KnownHosts is an optional
+path to a known_hosts file to check the host's key against.
+*/
+type Credential struct {
+	Host       string `json:"host"`
+	Username   string `json:"username,omitempty"`
+	Password   string `json:"password,omitempty"`
+	SSHKey     string `json:"ssh_key,omitempty"`
+	KnownHosts string `json:"known_hosts,omitempty"`
+}
+
+// SetCredentials sets the (global) per-host credentials used for cloning
+func SetCredentials(creds []Credential) {
+	credentialsLock.Lock()
+	defer credentialsLock.Unlock()
+
+	credentials = creds
+}
+
+// LoadCredentials reads a JSON array of credentials from the file at path
+func LoadCredentials(path string) ([]Credential, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds []Credential
+	if err := json.Unmarshal(raw, &creds); err != nil {
+		return nil, err
+	}
+
+	for _, cred := range creds {
+		if cred.Host == "" {
+			return nil, fmt.Errorf("credentials in %s must each have a host", path)
+		}
+	}
+
+	return creds, nil
+}
+
+func credentialFor(host string) *Credential {
+	credentialsLock.RLock()
+	defer credentialsLock.RUnlock()
+
+	for _, cred := range credentials {
+		if strings.EqualFold(cred.Host, host) {
+			ret := cred
+			return &ret
+		}
+	}
+	return nil
+}
+
+/*
+remote is where a job's repo is cloned from, along with what's needed to
+authenticate with it.  secrets are the strings that must not show up in logs,
+and config is git config that's passed to git through its environment.
+*/
+type remote struct {
+	scheme  string
+	host    string
+	url     string
+	env     []string
+	config  [][2]string
+	secrets []string
+}
+
+/*
+parseRemote parses a clone URL, which may be any URL with one of the
+remoteSchemes or an scp-like SSH remote.  The scheme (always "ssh" for
+scp-like remotes) and host are returned.
+*/
+func parseRemote(raw string) (scheme, host string, err error) {
+	if !strings.Contains(raw, "://") {
+		if match := scpLikeRegex.FindStringSubmatch(raw); match != nil {
+			return "ssh", match[2], nil
+		}
+		return "", "", fmt.Errorf("%q is not a valid clone url", raw)
+	}
+
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return "", "", err
+	}
+
+	if !remoteSchemes[parsed.Scheme] {
+		return "", "", fmt.Errorf("%q is not a supported clone url scheme", parsed.Scheme)
+	}
+
+	if parsed.Scheme != "file" && parsed.Host == "" {
+		return "", "", fmt.Errorf("%q is missing a host", raw)
+	}
+
+	return parsed.Scheme, parsed.Hostname(), nil
+}
+
+/*
+repoFromRemote guesses the account and repo from the last two elements of the
+clone URL's path, e.g. foo and bar for git@example.com:foo/bar.git
+*/
+func repoFromRemote(raw string) (account, repo string) {
+	path := raw
+	if strings.Contains(raw, "://") {
+		if parsed, err := url.Parse(raw); err == nil {
+			path = parsed.Path
+		}
+	} else if match := scpLikeRegex.FindStringSubmatch(raw); match != nil {
+		path = match[3]
+	}
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	repo = strings.TrimSuffix(parts[len(parts)-1], ".git")
+	if len(parts) > 1 {
+		account = parts[len(parts)-2]
+	}
+
+	return account, repo
+}
+
+/*
+remote returns the remote to clone the job's repo from.  Jobs without a clone
+URL are cloned from GitHub.  Credentials configured for the remote's host are
+used if there are any, and otherwise the GitHub API token is used for HTTPS
+remotes on GitHub (and only there).
+*/
+func (job *Job) remote() (*remote, error) {
+	raw := job.CloneURL
+	if raw == "" {
+		raw = fmt.Sprintf("https://%s/%s/%s", githubHost, job.Account, job.Repo)
+	}
+
+	scheme, host, err := parseRemote(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	// never prompt for anything
+	ret := &remote{scheme: scheme, host: host, url: raw, env: []string{"GIT_TERMINAL_PROMPT=0"}}
+	cred := credentialFor(host)
+
+	switch scheme {
+	case "http", "https":
+		parsed, _ := url.Parse(raw)
+		user := parsed.User
+		switch {
+		case user != nil:
+			if password, ok := user.Password(); ok && password != "" {
+				ret.secrets = append(ret.secrets, password)
+			}
+		case cred != nil && cred.Password != "":
+			user = url.UserPassword(cred.Username, cred.Password)
+			ret.secrets = append(ret.secrets, cred.Password)
+		case cred != nil && cred.Username != "":
+			user = url.User(cred.Username)
+		case cred == nil && scheme == "https" && host == githubHost && job.GitHubAPIToken != "":
+			// GitHub takes the token as the username, with no password
+			user = url.UserPassword(job.GitHubAPIToken, "")
+			ret.secrets = append(ret.secrets, job.GitHubAPIToken)
+		}
+		parsed.User = nil
+		ret.url = parsed.String()
+
+		if user != nil {
+			ret.authenticate(fmt.Sprintf("%s://%s/", scheme, parsed.Host), user)
+		}
+	case "ssh":
+		sshCommand := []string{"ssh", "-o", "BatchMode=yes"}
+		if cred != nil && cred.SSHKey != "" {
+			sshCommand = append(sshCommand, "-i", shellQuote(cred.SSHKey), "-o", "IdentitiesOnly=yes")
+		}
+		if cred != nil && cred.KnownHosts != "" {
+			sshCommand = append(sshCommand, "-o", "UserKnownHostsFile="+shellQuote(cred.KnownHosts))
+		}
+		ret.env = append(ret.env, "GIT_SSH_COMMAND="+strings.Join(sshCommand, " "))
+	case "file":
+		// git refuses to clone local submodules unless told it's ok, and
+		// the server has already been told it's ok to clone local repos
+		ret.config = append(ret.config, [2]string{"protocol.file.allow", "always"})
+	}
+
+	return ret, nil
+}
+
+/*
+authenticate has git use user for every url under prefix, so that submodules on
+the same host are cloned with the same credentials.  They're passed to git as
+config in its environment rather than in the url, which would show up in ps
+and be saved in .git/config (and so in the build context).
+*/
+func (r *remote) authenticate(prefix string, user *url.Userinfo) {
+	password, ok := user.Password()
+	if !ok {
+		r.config = append(r.config, [2]string{"credential." + prefix + ".username", user.Username()})
+		return
+	}
+
+	auth := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+	r.config = append(r.config, [2]string{"http." + prefix + ".extraHeader", "Authorization: Basic " + auth})
+	r.secrets = append(r.secrets, auth)
+}
+
+// environ is the environment git is run with for the remote
+func (r *remote) environ() []string {
+	env := append(os.Environ(), r.env...)
+	if len(r.config) == 0 {
+		return env
+	}
+
+	env = append(env, fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(r.config)))
+	for i, config := range r.config {
+		env = append(env,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, config[0]),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, config[1]),
+		)
+	}
+	return env
+}
+
+// redact removes any secrets from output
+func (r *remote) redact(output string) string {
+	for _, secret := range r.secrets {
+		output = strings.Replace(output, secret, "<redacted>", -1)
+		output = strings.Replace(output, url.QueryEscape(secret), "<redacted>", -1)
+	}
+	return output
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
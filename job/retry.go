@@ -0,0 +1,122 @@
+package job
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/winchman/builder-core/communication"
+)
+
+const (
+	// DefaultRetryAttempts is the number of times a clone or push is
+	// attempted when no retry policy is configured (i.e. no retries)
+	DefaultRetryAttempts = 1
+
+	// DefaultRetryBackoff is how long to wait before retrying a failed clone
+	// or push when no backoff is configured.  The wait doubles after each
+	// failed attempt.
+	DefaultRetryBackoff = 2 * time.Second
+
+	// RetryEventType is the type of the event recorded for a failed attempt
+	// that is going to be retried
+	RetryEventType = "RetryEvent"
+
+	// a retry isn't one of builder-core's event types
+	retryEventType comm.EventType = 255
+)
+
+var (
+	retryPolicy     = RetryPolicy{Attempts: DefaultRetryAttempts, Backoff: DefaultRetryBackoff}
+	retryPolicyLock sync.RWMutex
+)
+
+/*
+RetryPolicy decides how many times a step that may fail for transient reasons
+(cloning the repo, pushing an image) is attempted, and how long to wait before
+the first retry.  The wait doubles after each failed attempt.
+*/
+type RetryPolicy struct {
+	Attempts int
+	Backoff  time.Duration
+}
+
+// SetRetryPolicy sets the (global) retry policy for clones and pushes
+func SetRetryPolicy(p RetryPolicy) {
+	if p.Attempts < 1 {
+		p.Attempts = DefaultRetryAttempts
+	}
+	if p.Backoff <= 0 {
+		p.Backoff = DefaultRetryBackoff
+	}
+
+	retryPolicyLock.Lock()
+	defer retryPolicyLock.Unlock()
+
+	retryPolicy = p
+}
+
+func currentRetryPolicy() RetryPolicy {
+	retryPolicyLock.RLock()
+	defer retryPolicyLock.RUnlock()
+
+	return retryPolicy
+}
+
+/*
+retry calls fn until it succeeds, it has been called policy.Attempts times or
+ctx is done, waiting between attempts.  Before each retry, failed is called
+with the attempt that failed, its error and how long until the next attempt.
+fn returns a permanentError for a failure that isn't worth retrying, in which
+case the error it wraps is returned straight away.
+*/
+func retry(ctx context.Context, policy RetryPolicy, failed func(attempt int, err error, wait time.Duration), fn func() error) error {
+	wait := policy.Backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if permanent, ok := err.(*permanentError); ok {
+			return permanent.err
+		}
+		if err == nil || attempt >= policy.Attempts {
+			return err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		failed(attempt, err, wait)
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		wait *= 2
+	}
+}
+
+// permanentError is a failure that another attempt won't fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+
+// retryEvent is the event recorded for a failed attempt that will be retried
+type retryEvent struct {
+	data map[string]interface{}
+}
+
+func newRetryEvent(step string, attempt, attempts int, err error, wait time.Duration) *retryEvent {
+	return &retryEvent{data: map[string]interface{}{
+		"step":     step,
+		"attempt":  attempt,
+		"attempts": attempts,
+		"error":    err,
+		"wait":     wait.String(),
+	}}
+}
+
+func (e *retryEvent) EventType() comm.EventType    { return retryEventType }
+func (e *retryEvent) Data() map[string]interface{} { return e.data }
+func (e *retryEvent) Name() string                 { return RetryEventType }
@@ -0,0 +1,209 @@
+package job_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rafecolton/docker-builder/job"
+)
+
+var _ = Describe("retries", func() {
+	var (
+		tmpdir string
+		config *Config
+	)
+
+	git := func(dir string, args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		out, err := cmd.CombinedOutput()
+		Expect(err).To(BeNil(), string(out))
+	}
+
+	newJobFor := func(cloneURL, ref string) *Job {
+		spec, _ := NewSpec([]byte(`{"clone_url": "` + cloneURL + `", "ref": "` + ref + `"}`))
+		req, _ := makeRequest("POST", "jobs", nil)
+		return NewJob(config, spec, req)
+	}
+
+	newJob := func() *Job {
+		return newJobFor("file://"+tmpdir+"/bare.git", "master")
+	}
+
+	// newRepo makes the bare repo that newJob clones from
+	newRepo := func() {
+		src := tmpdir + "/src"
+		os.MkdirAll(src, 0755)
+		git(src, "init", "-q", "-b", "master")
+		ioutil.WriteFile(src+"/Bobfile", []byte("[docker]\n"), 0644)
+		git(src, "add", "Bobfile")
+		git(src, "commit", "-q", "-m", "initial commit")
+		git(tmpdir, "init", "-q", "--bare", "-b", "master", tmpdir+"/bare.git")
+		git(src, "push", "-q", tmpdir+"/bare.git", "master")
+	}
+
+	retryEvents := func(job *Job) []Event {
+		var ret []Event
+		for _, e := range job.Events() {
+			if e.Type == RetryEventType {
+				ret = append(ret, e)
+			}
+		}
+		return ret
+	}
+
+	BeforeEach(func() {
+		tmpdir, _ = ioutil.TempDir("", "retry")
+		config = &Config{
+			Workdir: tmpdir + "/work",
+			Logger:  &logrus.Logger{Out: ioutil.Discard, Formatter: &logrus.JSONFormatter{}, Level: logrus.PanicLevel},
+		}
+	})
+
+	AfterEach(func() {
+		SetRetryPolicy(RetryPolicy{})
+		os.RemoveAll(tmpdir)
+	})
+
+	It("doesn't retry a failed clone by default", func() {
+		job := newJob()
+		_, err := job.Clone()
+		Expect(err).ToNot(BeNil())
+		Expect(retryEvents(job)).To(BeEmpty())
+	})
+
+	It("gives up on a clone after the configured number of attempts", func() {
+		SetRetryPolicy(RetryPolicy{Attempts: 3, Backoff: time.Millisecond})
+
+		job := newJob()
+		_, err := job.Clone()
+		Expect(err).ToNot(BeNil())
+
+		events := retryEvents(job)
+		Expect(events).To(HaveLen(2))
+		Expect(events[0].Data["step"]).To(Equal("clone"))
+		Expect(events[0].Data["attempt"]).To(Equal(1))
+		Expect(events[0].Data["attempts"]).To(Equal(3))
+		Expect(events[0].Data["wait"]).To(Equal("1ms"))
+		Expect(events[0].Data["error"]).ToNot(BeEmpty())
+		Expect(events[1].Data["attempt"]).To(Equal(2))
+		Expect(events[1].Data["wait"]).To(Equal("2ms"))
+	})
+
+	It("clones once the remote comes back", func() {
+		SetRetryPolicy(RetryPolicy{Attempts: 2, Backoff: time.Second})
+
+		job := newJob()
+		done := make(chan error)
+		go func() {
+			path, err := job.Clone()
+			if err == nil {
+				_, err = os.Stat(path + "/Bobfile")
+			}
+			done <- err
+		}()
+
+		Eventually(func() []Event { return retryEvents(job) }).Should(HaveLen(1))
+		newRepo()
+
+		Eventually(done, 5*time.Second).Should(Receive(BeNil()))
+	})
+
+	It("doesn't retry checking out a ref that isn't there", func() {
+		SetRetryPolicy(RetryPolicy{Attempts: 3, Backoff: time.Millisecond})
+		newRepo()
+
+		job := newJobFor("file://"+tmpdir+"/bare.git", "no-such-branch")
+		_, err := job.Clone()
+		Expect(err).ToNot(BeNil())
+		Expect(retryEvents(job)).To(BeEmpty())
+	})
+
+	It("doesn't retry a clone that the remote wants credentials for", func() {
+		SetRetryPolicy(RetryPolicy{Attempts: 3, Backoff: time.Millisecond})
+
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			w.WriteHeader(401)
+		}))
+		defer server.Close()
+
+		job := newJobFor(server.URL+"/foo/bar.git", "master")
+		_, err := job.Clone()
+		Expect(err).ToNot(BeNil())
+		Expect(retryEvents(job)).To(BeEmpty())
+		Expect(atomic.LoadInt32(&requests)).To(BeNumerically(">", 0))
+	})
+
+	It("stops retrying when the job is cancelled", func() {
+		SetRetryPolicy(RetryPolicy{Attempts: 5, Backoff: time.Hour})
+
+		job := newJob()
+		done := make(chan error)
+		go func() {
+			_, err := job.Clone()
+			done <- err
+		}()
+
+		Eventually(func() []Event { return retryEvents(job) }).Should(HaveLen(1))
+		Expect(job.Cancel()).To(BeNil())
+		Eventually(done).Should(Receive(Not(BeNil())))
+	})
+
+	Context("for pushes", func() {
+		pushRetry := func(bobfile, section string) (RetryPolicy, error) {
+			ioutil.WriteFile(tmpdir+"/Bobfile", []byte(bobfile), 0644)
+			return PushRetry(tmpdir+"/Bobfile", section)
+		}
+
+		It("uses the server's policy unless the Bobfile says otherwise", func() {
+			SetRetryPolicy(RetryPolicy{Attempts: 2, Backoff: time.Second})
+
+			policy, err := pushRetry("[[container]]\nname = \"app\"\n", "app")
+			Expect(err).To(BeNil())
+			Expect(policy).To(Equal(RetryPolicy{Attempts: 2, Backoff: time.Second}))
+		})
+
+		It("prefers the container section over the container globals", func() {
+			bobfile := `
+[container_globals]
+push_attempts = 3
+push_backoff = "5s"
+
+[[container]]
+name = "app"
+push_attempts = 4
+
+[[container]]
+name = "worker"
+`
+			policy, err := pushRetry(bobfile, "app")
+			Expect(err).To(BeNil())
+			Expect(policy).To(Equal(RetryPolicy{Attempts: 4, Backoff: 5 * time.Second}))
+
+			policy, err = pushRetry(bobfile, "worker")
+			Expect(err).To(BeNil())
+			Expect(policy).To(Equal(RetryPolicy{Attempts: 3, Backoff: 5 * time.Second}))
+		})
+
+		It("rejects an invalid backoff", func() {
+			_, err := pushRetry("[[container]]\nname = \"app\"\npush_backoff = \"soon\"\n", "app")
+			Expect(err).ToNot(BeNil())
+		})
+	})
+})
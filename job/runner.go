@@ -0,0 +1,405 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/moby/moby/pkg/archive"
+	"github.com/modcloth/go-fileutils"
+	"github.com/rafecolton/go-dockerclient-quick"
+	"github.com/winchman/builder-core"
+	"github.com/winchman/builder-core/communication"
+	"github.com/winchman/builder-core/filecheck"
+	"github.com/winchman/builder-core/parser"
+)
+
+// digestRegex matches the manifest digest in the output of a `docker push`
+var digestRegex = regexp.MustCompile(`digest: (sha256:[0-9a-f]{64})`)
+
+/*
+runBuild is a cancellable version of runner.RunBuild from builder-core.  It
+runs the same build/tag/push command sequence and reports on the same
+channels, but it checks ctx before every docker command, stops the docker
+command that is running when ctx is done (see contextClient), and removes the temporary uuid tag when the build is stopped part way
+through.  Failed pushes are retried according to the policy pushRetry returns
+for their container section.  The error sent on the exit channel is ctx.Err()
+if ctx is done before the build finishes.
+*/
+func runBuild(ctx context.Context, opts runner.Options, pushRetry func(section string) RetryPolicy) (comm.LogChan, comm.EventChan, comm.ExitChan) {
+	var log = make(chan comm.LogEntry, 1)
+	var event = make(chan comm.Event, 1)
+	var exit = make(chan error)
+
+	go func() {
+		if opts.UnitConfig == nil {
+			exit <- errors.New("unit config may not be nil")
+			return
+		}
+
+		p := &pipeline{
+			digests:    map[string]string{},
+			pushRetry:  pushRetry,
+			ctx:        ctx,
+			contextDir: opts.ContextDir,
+			log:        log,
+			event:      event,
+			reporter:   comm.NewReporter(log, event),
+			stdout:     comm.NewLogEntryWriter(log),
+		}
+
+		sequence := parser.NewParser(parser.NewParserOptions{
+			ContextDir: opts.ContextDir,
+			Log:        log,
+			Event:      event,
+		}).Parse(opts.UnitConfig)
+		if sequence == nil {
+			exit <- errors.New("unable to parse Bobfile into a command sequence")
+			return
+		}
+
+		exit <- p.run(sequence)
+	}()
+
+	return log, event, exit
+}
+
+type pipeline struct {
+	ctx          context.Context
+	contextDir   string
+	dockerClient dockerclient.DockerClient
+	cmdClient    dockerclient.DockerClient
+	log          comm.LogChan
+	event        comm.EventChan
+	reporter     *comm.Reporter
+	stdout       io.Writer
+	pushRetry    func(section string) RetryPolicy
+
+	// digests maps "repo:tag" to the manifest digest of each pushed image
+	digests     map[string]string
+	digestsLock sync.Mutex
+}
+
+// sectionEvent is an event reported by a docker command, annotated with the
+// name of the container section the command belongs to
+type sectionEvent struct {
+	comm.Event
+	data map[string]interface{}
+}
+
+func (e *sectionEvent) Data() map[string]interface{} { return e.data }
+
+/*
+sectionReporter returns a reporter for the commands in a container section,
+along with the channel it sends events on.  Events sent to either are annotated
+with the section's name before being passed along.  The returned function must
+be called once the section is done.
+*/
+func (p *pipeline) sectionReporter(name string) (*comm.Reporter, chan<- comm.Event, func()) {
+	events := make(chan comm.Event)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for e := range events {
+			data := map[string]interface{}{"container_section": name}
+			for key, value := range e.Data() {
+				data[key] = value
+			}
+			if isPushCompletedEvent(e) {
+				if digest := p.digest(data["repo"], data["tag"]); digest != "" {
+					data["digest"] = digest
+				}
+			}
+			p.event <- &sectionEvent{Event: e, data: data}
+		}
+	}()
+
+	return comm.NewReporter(p.log, events), events, func() {
+		close(events)
+		<-done
+	}
+}
+
+func (p *pipeline) run(sequence *parser.CommandSequence) error {
+	p.reporter.Event(comm.EventOptions{EventType: comm.RequestedEvent})
+
+	client, err := newDockerClient()
+	if err != nil {
+		return err
+	}
+	p.dockerClient = client
+
+	// commands use a client that's cut off when ctx is done, but cleaning up
+	// after them still has to work afterwards
+	cmdClient, release := contextClient(p.ctx, client)
+	defer release()
+	p.cmdClient = cmdClient
+
+	for _, seq := range sequence.Commands {
+		if err := p.ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := p.runSubSequence(seq); err != nil {
+			return err
+		}
+	}
+
+	p.reporter.Event(comm.EventOptions{EventType: comm.CompletedEvent})
+
+	return nil
+}
+
+func (p *pipeline) runSubSequence(seq *parser.SubSequence) error {
+	var imageID string
+	var err error
+
+	workdir, err := ioutil.TempDir("", "bob")
+	if err != nil {
+		return err
+	}
+	defer fileutils.RmRF(workdir)
+
+	if err = p.setup(seq.Metadata, workdir); err != nil {
+		return err
+	}
+
+	// the temporary tag is removed no matter how the sequence ends
+	defer p.removeTemporaryTag(seq.Metadata.UUID)
+
+	reporter, events, closeReporter := p.sectionReporter(seq.Metadata.Name)
+	defer closeReporter()
+
+	p.reporter.Log(
+		logrus.WithField("container_section", seq.Metadata.Name),
+		"running commands for container section",
+	)
+
+	for _, cmd := range seq.SubCommand {
+		if err := p.ctx.Err(); err != nil {
+			p.reporter.LogLevel(
+				logrus.WithField("container_section", seq.Metadata.Name),
+				"build stopped before running remaining commands",
+				logrus.WarnLevel,
+			)
+			return err
+		}
+
+		cmd = cmd.WithOpts(&parser.DockerCmdOpts{
+			DockerClient: p.cmdClient,
+			Image:        imageID,
+			ImageUUID:    seq.Metadata.UUID,
+			SkipPush:     SkipPush,
+			Stdout:       p.stdout,
+			Workdir:      workdir,
+			Reporter:     reporter,
+		})
+		p.instrument(cmd, seq.Metadata.Name, events)
+
+		p.reporter.Log(logrus.WithField("command", cmd.Message()), "running docker command")
+
+		start := time.Now()
+		imageID, err = cmd.Run()
+		if step := cmdStep(cmd); step != "" {
+			observeStep(step, start)
+		}
+		if err != nil {
+			switch err.(type) {
+			case parser.NilClientError:
+				continue
+			default:
+				if ctxErr := p.ctx.Err(); ctxErr != nil {
+					return ctxErr
+				}
+				return err
+			}
+		}
+
+		p.reporter.Log(
+			logrus.WithFields(logrus.Fields{
+				"command":  cmd.Message(),
+				"image_id": imageID,
+			}),
+			"finished running docker command",
+		)
+	}
+
+	return nil
+}
+
+/*
+instrument makes the docker API calls made by cmd abort when the pipeline's
+context is done, where the command allows it, picks the manifest digest out of
+the output of pushes and retries failed pushes.  Each retry is logged and
+reported on events.
+*/
+func (p *pipeline) instrument(cmd parser.DockerCmd, section string, events chan<- comm.Event) {
+	switch cmd := cmd.(type) {
+	case *parser.PushCmd:
+		if cmd.PushFunc == nil {
+			return
+		}
+		push := cmd.PushFunc
+		cmd.PushFunc = func(opts docker.PushImageOptions, auth docker.AuthConfiguration) error {
+			opts.Context = p.ctx
+			opts.OutputStream = &digestWriter{
+				Writer: opts.OutputStream,
+				found: func(digest string) {
+					p.digestsLock.Lock()
+					defer p.digestsLock.Unlock()
+					p.digests[opts.Name+":"+opts.Tag] = digest
+				},
+			}
+			policy := p.pushRetry(section)
+			failed := func(attempt int, err error, wait time.Duration) {
+				p.reporter.LogLevel(
+					logrus.WithFields(logrus.Fields{
+						"container_section": section,
+						"command":           cmd.Message(),
+						"attempt":           attempt,
+						"attempts":          policy.Attempts,
+						"error":             err,
+						"retry_in":          wait,
+					}),
+					"push failed, retrying",
+					logrus.WarnLevel,
+				)
+				event := newRetryEvent("push", attempt, policy.Attempts, err, wait)
+				event.data["repo"] = opts.Name
+				event.data["tag"] = opts.Tag
+				events <- event
+			}
+
+			return retry(p.ctx, policy, failed, func() error {
+				return push(opts, auth)
+			})
+		}
+	}
+}
+
+func (p *pipeline) digest(repo, tag interface{}) string {
+	p.digestsLock.Lock()
+	defer p.digestsLock.Unlock()
+
+	r, _ := repo.(string)
+	t, _ := tag.(string)
+	return p.digests[r+":"+t]
+}
+
+// digestWriter passes push output along, calling found with any manifest
+// digest it sees on the way
+type digestWriter struct {
+	io.Writer
+	found func(digest string)
+}
+
+func (w *digestWriter) Write(b []byte) (int, error) {
+	if match := digestRegex.FindSubmatch(b); match != nil {
+		w.found(string(match[1]))
+	}
+	if w.Writer == nil {
+		return len(b), nil
+	}
+	return w.Writer.Write(b)
+}
+
+/*
+isPushCompletedEvent indicates whether e reports the end of a push.  The
+builder-core PushCmd reports both the start and the end of a push as a
+PushEvent, and only the latter includes an "error" (which may be nil).
+*/
+func isPushCompletedEvent(e comm.Event) bool {
+	if e.EventType() != comm.PushEvent {
+		return false
+	}
+	_, ok := e.Data()["error"]
+	return ok
+}
+
+// setup moves the build context for the container section into workdir (the
+// same as the builder-core Builder does)
+func (p *pipeline) setup(meta *parser.SubSequenceMetadata, workdir string) error {
+	pathToDockerfile, err := filecheck.NewTrustedFilePath(filecheck.NewTrustedFilePathOptions{
+		File: meta.Dockerfile,
+		Top:  p.contextDir,
+	})
+	if err != nil {
+		return err
+	}
+
+	if pathToDockerfile.Sanitize(); pathToDockerfile.State != filecheck.OK {
+		return pathToDockerfile.Error
+	}
+
+	contextDir := pathToDockerfile.Top()
+	tarStream, err := archive.TarWithOptions(contextDir, &archive.TarOptions{
+		Compression:     archive.Uncompressed,
+		ExcludePatterns: []string{"Dockerfile"},
+	})
+	if err != nil {
+		return err
+	}
+	defer tarStream.Close()
+
+	if err := archive.Untar(tarStream, workdir, nil); err != nil {
+		return err
+	}
+
+	return fileutils.CpWithArgs(
+		contextDir+"/"+meta.Dockerfile,
+		workdir+"/Dockerfile",
+		fileutils.CpArgs{PreserveModTime: true},
+	)
+}
+
+func (p *pipeline) removeTemporaryTag(uuid string) {
+	regex := ":" + uuid + "$"
+	image, err := p.dockerClient.LatestImageByRegex(regex)
+	if err != nil || image == nil {
+		return
+	}
+
+	for _, tag := range image.RepoTags {
+		if matched, _ := regexp.MatchString(regex, tag); !matched {
+			continue
+		}
+
+		p.reporter.LogLevel(
+			logrus.WithFields(logrus.Fields{
+				"image_id": image.ID,
+				"tag":      tag,
+			}),
+			"deleting temporary tag",
+			logrus.DebugLevel,
+		)
+
+		if err := p.dockerClient.Client().RemoveImage(tag); err != nil {
+			p.reporter.LogLevel(
+				logrus.WithField("err", err),
+				"error deleting temporary tag",
+				logrus.WarnLevel,
+			)
+		}
+		return
+	}
+}
+
+// cmdStep is the step of a job that cmd is part of, for measuring durations
+func cmdStep(cmd parser.DockerCmd) string {
+	switch cmd.(type) {
+	case *parser.BuildCmd:
+		return StepBuild
+	case *parser.TagCmd:
+		return StepTag
+	case *parser.PushCmd:
+		return StepPush
+	}
+	return ""
+}
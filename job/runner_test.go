@@ -0,0 +1,124 @@
+package job_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rafecolton/docker-builder/job"
+)
+
+// fakeDocker is a Docker client for a daemon that has no images
+type fakeDocker struct {
+	client *docker.Client
+}
+
+func (d *fakeDocker) Client() *docker.Client { return d.client }
+
+func (d *fakeDocker) LatestImageByRegex(string) (*docker.APIImages, error) { return nil, nil }
+
+var _ = Describe("running builds", func() {
+	var (
+		tmpdir  string
+		config  *Config
+		daemon  *httptest.Server
+		started chan struct{}
+		aborted chan struct{}
+		restore func()
+	)
+
+	// newJob makes a job that builds an uploaded context with a Bobfile
+	newJob := func(timeout string) *Job {
+		contextDir := tmpdir + "/context"
+		os.MkdirAll(contextDir, 0755)
+		ioutil.WriteFile(contextDir+"/Dockerfile", []byte("FROM scratch\n"), 0644)
+		ioutil.WriteFile(contextDir+"/Bobfile", []byte(`---
+container_globals:
+  skip_push: true
+container:
+- name: app
+  Dockerfile: Dockerfile
+  registry: quay.io/foo
+  project: bar
+  tags:
+  - latest
+`), 0644)
+
+		spec := &Spec{RepoOwner: "foo", RepoName: "bar", ContextDir: contextDir, Timeout: timeout}
+		Expect(spec.Validate()).To(BeNil())
+		req, _ := makeRequest("POST", "jobs", nil)
+		return NewJob(config, spec, req)
+	}
+
+	BeforeEach(func() {
+		tmpdir, _ = ioutil.TempDir("", "runner")
+		config = &Config{
+			Workdir: tmpdir + "/work",
+			Logger:  &logrus.Logger{Out: ioutil.Discard, Formatter: &logrus.JSONFormatter{}, Level: logrus.PanicLevel},
+		}
+
+		// the daemon never finishes a build, as if a RUN step were stuck
+		started = make(chan struct{})
+		aborted = make(chan struct{})
+		daemon = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if !strings.HasSuffix(req.URL.Path, "/build") {
+				w.Write([]byte("[]"))
+				return
+			}
+			io.Copy(ioutil.Discard, req.Body)
+			w.Write([]byte(`{"stream": "Step 1/2 : RUN sleep infinity"}` + "\n"))
+			w.(http.Flusher).Flush()
+			close(started)
+			<-req.Context().Done()
+			close(aborted)
+		}))
+		client, err := docker.NewClient(daemon.URL)
+		Expect(err).To(BeNil())
+		restore = SetDockerClient(&fakeDocker{client: client})
+
+		TestMode = false
+	})
+
+	AfterEach(func() {
+		TestMode = true
+		restore()
+		daemon.Close()
+		os.RemoveAll(tmpdir)
+	})
+
+	It("stops a stuck build when the job times out", func() {
+		job := newJob("200ms")
+
+		Expect(job.Process()).To(Equal(context.DeadlineExceeded))
+		Eventually(aborted, "5s").Should(BeClosed())
+
+		snapshot := job.Snapshot()
+		Expect(snapshot.Status).To(Equal(StatusTimedOut))
+		Expect(snapshot.Error).To(Equal("job timed out after 200ms"))
+	})
+
+	It("stops a build that is in progress when the job is cancelled", func() {
+		job := newJob("")
+		processed := make(chan error, 1)
+		go func() { processed <- job.Process() }()
+
+		Eventually(started, "5s").Should(BeClosed())
+		Expect(job.Cancel()).To(BeNil())
+
+		Eventually(processed, "5s").Should(Receive(Equal(context.Canceled)))
+		Eventually(aborted, "5s").Should(BeClosed())
+
+		snapshot := job.Snapshot()
+		Expect(snapshot.Status).To(Equal(StatusCancelled))
+		Expect(snapshot.Error).To(Equal("job was cancelled"))
+	})
+})
@@ -1,8 +1,58 @@
 package job
 
+import (
+	"sort"
+	"strings"
+)
+
 // ByCreatedDescending is a type for sorting an array of jobs by created date, descending
 type ByCreatedDescending []*Job
 
 func (l ByCreatedDescending) Len() int           { return len(l) }
 func (l ByCreatedDescending) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
 func (l ByCreatedDescending) Less(i, j int) bool { return l[i].Created.Unix() > l[j].Created.Unix() }
+
+// DefaultSort is the order of the jobs listed by GET /jobs, newest first
+const DefaultSort = "-created"
+
+// jobSorts are the fields jobs may be sorted by, each with its (ascending) less func
+var jobSorts = map[string]func(a, b *Job) bool{
+	"account":   func(a, b *Job) bool { return a.Account < b.Account },
+	"completed": func(a, b *Job) bool { return a.Completed.Before(b.Completed) },
+	"created":   func(a, b *Job) bool { return a.Created.Before(b.Created) },
+	"id":        func(a, b *Job) bool { return a.ID < b.ID },
+	"ref":       func(a, b *Job) bool { return a.Ref < b.Ref },
+	"repo":      func(a, b *Job) bool { return a.Repo < b.Repo },
+	"status":    func(a, b *Job) bool { return a.Status < b.Status },
+}
+
+/*
+parseSort splits a sort like -created into the field and whether or not it's
+descending.  ok is false if jobs can't be sorted by the field.
+*/
+func parseSort(s string) (field string, descending bool, ok bool) {
+	field = strings.TrimPrefix(s, "-")
+	_, ok = jobSorts[field]
+	return field, field != s, ok
+}
+
+/*
+sortJobs sorts jobs by field.  Jobs that are equal by that field are sorted by
+id so that paging through them is stable.
+*/
+func sortJobs(jobs []*Job, field string, descending bool) {
+	less := jobSorts[field]
+	sort.SliceStable(jobs, func(i, j int) bool {
+		a, b := jobs[i], jobs[j]
+		if descending {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+}
@@ -0,0 +1,115 @@
+package job
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+/*
+Store is the interface for anything that keeps track of jobs.  The default
+store only keeps jobs in memory, so job history is lost when the server
+restarts.  Use OpenDataDir to persist jobs on disk instead.
+*/
+type Store interface {
+	// Save adds the job to the store or updates it if it already exists
+	Save(job *Job) error
+
+	// Get returns the job with the given id, or nil if there is no such job
+	Get(id string) *Job
+
+	// All returns every job in the store, in no particular order
+	All() []*Job
+
+	// Delete removes the job with the given id from the store
+	Delete(id string) error
+}
+
+var (
+	store Store = NewMemoryStore()
+
+	// logRoot is the directory under which job logs are written when jobs
+	// are being persisted, empty otherwise
+	logRoot string
+)
+
+//SetStore sets the (global) store used for keeping track of jobs
+func SetStore(s Store) {
+	store = s
+}
+
+/*
+OpenDataDir configures jobs to be persisted under dir.  Job records are
+reloaded from dir (see NewFileStore) and job logs are written to dir as well,
+so both survive server restarts.
+*/
+func OpenDataDir(dir string) error {
+	s, err := NewFileStore(filepath.Join(dir, "jobs.jsonl"))
+	if err != nil {
+		return err
+	}
+
+	store = s
+	logRoot = filepath.Join(dir, "logs")
+
+	return nil
+}
+
+/*
+MemoryStore is a Store that keeps jobs in memory only.  It is safe for
+concurrent use.
+*/
+type MemoryStore struct {
+	jobs map[string]*Job
+	lock sync.RWMutex
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{jobs: map[string]*Job{}}
+}
+
+// Save adds the job to the store or updates it if it already exists
+func (s *MemoryStore) Save(job *Job) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.jobs[job.ID] = job
+	return nil
+}
+
+// Get returns the job with the given id, or nil if there is no such job
+func (s *MemoryStore) Get(id string) *Job {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.jobs[id]
+}
+
+// All returns every job in the store, in no particular order
+func (s *MemoryStore) All() []*Job {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var ret = make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		ret = append(ret, job)
+	}
+	return ret
+}
+
+// count returns the number of jobs in the store
+func (s *MemoryStore) count() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return len(s.jobs)
+}
+
+// Delete removes the job with the given id from the store
+func (s *MemoryStore) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.jobs, id)
+	return nil
+}
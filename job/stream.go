@@ -0,0 +1,190 @@
+package job
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"golang.org/x/net/websocket"
+)
+
+// streamPollInterval is how often a streamed log is checked for new lines
+var streamPollInterval = 250 * time.Millisecond
+
+/*
+streamMessage is what gets sent over a WebSocket stream.  Log messages carry a
+single line of the log along with the offset from which to resume after it,
+and the final status message carries the finished job.
+*/
+type streamMessage struct {
+	Type   string `json:"type"`
+	Offset int64  `json:"offset,omitempty"`
+	Data   string `json:"data,omitempty"`
+	Job    *Job   `json:"job,omitempty"`
+}
+
+/*
+Stream is the handler function for the job log streaming route.  The log is
+sent as Server-Sent Events (or over a WebSocket if the request asks for an
+upgrade), starting from the byte offset given by the `offset` query parameter
+or the Last-Event-ID header.  New lines are sent as they are written until the
+job finishes, at which point a final "status" event containing the job is sent
+and the stream is closed.
+*/
+func Stream(params martini.Params, w http.ResponseWriter, req *http.Request) {
+	job := store.Get(params["id"])
+	if job == nil {
+		http.Error(w, "404 not found", 404)
+		return
+	}
+
+	offset, err := streamOffset(req)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+		websocket.Server{Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			streamWebSocket(ws, job, offset)
+		}}.ServeHTTP(w, req)
+		return
+	}
+
+	streamEvents(w, req, job, offset)
+}
+
+func streamOffset(req *http.Request) (int64, error) {
+	raw := req.FormValue("offset")
+	if raw == "" {
+		raw = req.Header.Get("Last-Event-ID")
+	}
+	if raw == "" {
+		return 0, nil
+	}
+
+	offset, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("%s is not a valid offset", raw)
+	}
+
+	return offset, nil
+}
+
+func streamEvents(w http.ResponseWriter, req *http.Request, job *Job, offset int64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+	flusher.Flush()
+
+	err := followLog(req.Context(), job, offset, func(line string, next int64) error {
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: log\ndata: %s\n\n", next, line); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	status, err := json.Marshal(job.Snapshot())
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: status\ndata: %s\n\n", status)
+	flusher.Flush()
+}
+
+func streamWebSocket(ws *websocket.Conn, job *Job, offset int64) {
+	err := followLog(ws.Request().Context(), job, offset, func(line string, next int64) error {
+		return websocket.JSON.Send(ws, &streamMessage{Type: "log", Offset: next, Data: line})
+	})
+	if err != nil {
+		return
+	}
+
+	websocket.JSON.Send(ws, &streamMessage{Type: "status", Job: job.Snapshot()})
+}
+
+/*
+followLog calls send for every complete line in the job's log from offset
+onward, waiting for new lines until the job has finished and the whole log
+has been sent.  The offset passed to send is the one just past the line.
+*/
+func followLog(ctx context.Context, job *Job, offset int64, send func(line string, next int64) error) error {
+	var file *os.File
+	var reader *bufio.Reader
+	var partial string
+
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	ticker := time.NewTicker(streamPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// checked before reading so that nothing written before the job
+		// finished can be missed
+		finished := job.finished()
+
+		if file == nil {
+			if f, err := os.Open(job.logDir + "/log.log"); err == nil {
+				if _, err := f.Seek(offset, io.SeekStart); err != nil {
+					f.Close()
+					return err
+				}
+				file = f
+				reader = bufio.NewReader(file)
+			}
+		}
+
+		for reader != nil {
+			chunk, err := reader.ReadString('\n')
+			if err != nil {
+				// hold on to an incomplete line until the rest of it is written
+				partial += chunk
+				break
+			}
+
+			line := partial + chunk
+			partial = ""
+			offset += int64(len(line))
+
+			if err := send(strings.TrimSuffix(line, "\n"), offset); err != nil {
+				return err
+			}
+		}
+
+		if finished {
+			if partial != "" {
+				return send(partial, offset+int64(len(partial)))
+			}
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
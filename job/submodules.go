@@ -0,0 +1,118 @@
+package job
+
+import (
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/modcloth/go-fileutils"
+)
+
+/*
+Submodule is a git submodule of the job's repo, along with the SHA it was
+checked out at.  Nested submodules have paths relative to the top of the repo.
+*/
+type Submodule struct {
+	Path string `json:"path"`
+	SHA  string `json:"sha"`
+}
+
+func copySubmodules(submodules []Submodule) []Submodule {
+	if submodules == nil {
+		return nil
+	}
+	ret := make([]Submodule, len(submodules))
+	copy(ret, submodules)
+	return ret
+}
+
+/*
+wantsSubmodules indicates whether or not the submodules of the repo cloned to
+path should be checked out, which is the case if either the job spec or the
+Bobfile asks for them.
+*/
+func (job *Job) wantsSubmodules(path string) bool {
+	if job.Recursive {
+		return true
+	}
+
+	opts, err := readBobfileOptions(path + "/" + job.Bobfile)
+	if err != nil {
+		// the build reports any problems with the Bobfile
+		job.Logger.WithField("error", err).Debug("unable to read options from Bobfile")
+		return false
+	}
+
+	return opts.Git.Submodules
+}
+
+/*
+checkoutSubmodules checks out the submodules of the repo at path (recursively)
+at the commits recorded for them, using the same remote configuration and
+credentials as the repo itself, and records their SHAs.
+*/
+func (job *Job) checkoutSubmodules(remote *remote, path string) error {
+	if !job.wantsSubmodules(path) {
+		return nil
+	}
+
+	git, err := fileutils.Which("git")
+	if err != nil {
+		return err
+	}
+
+	job.Logger.Info("checking out submodules")
+
+	// sync first in case the ref moved any submodule to a different url
+	if err := job.runGit(remote, git, path, "submodule", "sync", "--recursive"); err != nil {
+		return err
+	}
+	if err := job.runGit(remote, git, path, "submodule", "update", "--init", "--recursive", "--force"); err != nil {
+		return err
+	}
+
+	status, err := job.gitOutput(remote, git, path, "submodule", "status", "--recursive")
+	if err != nil {
+		return err
+	}
+
+	submodules := parseSubmoduleStatus(status)
+	for _, submodule := range submodules {
+		job.Logger.WithFields(logrus.Fields{
+			"path": submodule.Path,
+			"sha":  submodule.SHA,
+		}).Info("checked out submodule")
+	}
+
+	job.lock.Lock()
+	job.Submodules = submodules
+	job.lock.Unlock()
+
+	return nil
+}
+
+/*
+parseSubmoduleStatus parses the output of `git submodule status`, where each
+line is a status character (e.g. - for a submodule that isn't checked out)
+followed by the SHA, the path and (optionally) a description of the SHA in
+parens, e.g.
+
+	-5d8b2c7a0e3f... vendor/shared (v1.2.0)
+*/
+func parseSubmoduleStatus(status string) []Submodule {
+	var ret []Submodule
+
+	for _, line := range strings.Split(status, "\n") {
+		if len(line) < 2 {
+			continue
+		}
+
+		fields := strings.Fields(line[1:])
+		if len(fields) < 2 {
+			continue
+		}
+
+		ret = append(ret, Submodule{Path: fields[1], SHA: fields[0]})
+	}
+
+	return ret
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/rafecolton/docker-builder/conf"
+	"github.com/rafecolton/docker-builder/job"
 	"github.com/rafecolton/docker-builder/server"
 	"github.com/rafecolton/docker-builder/version"
 
@@ -31,6 +32,16 @@ func init() {
 		conf.Config.Port = 5000
 	}
 
+	// set default worker count
+	if conf.Config.Workers == 0 {
+		conf.Config.Workers = job.DefaultWorkers
+	}
+
+	// set default per-repo concurrency
+	if conf.Config.RepoConcurrency == 0 {
+		conf.Config.RepoConcurrency = job.DefaultRepoConcurrency
+	}
+
 	// set logger defaults
 	Logger = logrus.New()
 	Logger.Formatter = &logrus.TextFormatter{ForceColors: true}
@@ -146,6 +157,15 @@ func main() {
 					}(),
 					Usage: "docker builder server host (can be set in the environment via $DOCKER_BUILDER_HOST)",
 				},
+				cli.StringFlag{
+					Name:  "token",
+					Value: os.Getenv("DOCKER_BUILDER_TOKEN"),
+					Usage: "API token for the server, if it uses token auth (can be set in the environment via $DOCKER_BUILDER_TOKEN)",
+				},
+				cli.BoolFlag{
+					Name:  "upload",
+					Usage: "upload the working directory as the build context instead of having the server clone the repo",
+				},
 			},
 		},
 		{
@@ -174,6 +194,90 @@ func main() {
 					Name:  "skip-push",
 					Usage: "override Bobfile behavior and do not push any images (useful for testing)",
 				},
+				cli.IntFlag{
+					Name:  "workers",
+					Value: conf.Config.Workers,
+					Usage: "number of async jobs to process at once",
+				},
+				cli.IntFlag{
+					Name:  "repo-concurrency",
+					Value: conf.Config.RepoConcurrency,
+					Usage: "number of async jobs for the same repo to process at once",
+				},
+				cli.BoolFlag{
+					Name:  "dedup-jobs",
+					Usage: "respond with the job already in flight for the same account, repo, commit and Bobfile instead of starting another",
+				},
+				cli.StringFlag{
+					Name:  "job-timeout",
+					Value: "",
+					Usage: "how long a job may take once it leaves the queue, unless its request says otherwise, e.g. 30m (default no timeout)",
+				},
+				cli.IntFlag{
+					Name:  "retry-attempts",
+					Value: 0,
+					Usage: "how many times a clone or push is attempted before the job fails (default 1, i.e. no retries)",
+				},
+				cli.StringFlag{
+					Name:  "retry-backoff",
+					Value: "",
+					Usage: "how long to wait before retrying a failed clone or push, doubling after each attempt (default 2s)",
+				},
+				cli.StringFlag{
+					Name:  "data-dir",
+					Value: "",
+					Usage: "directory in which job history and logs are persisted",
+				},
+				cli.StringFlag{
+					Name:  "retention-max-age",
+					Value: "",
+					Usage: "remove finished jobs (with their workdirs and logs) this long after they finish, e.g. 72h",
+				},
+				cli.IntFlag{
+					Name:  "retention-max-jobs",
+					Value: 0,
+					Usage: "remove the oldest finished jobs once there are more than this many",
+				},
+				cli.StringFlag{
+					Name:  "retention-max-disk",
+					Value: "",
+					Usage: "remove the oldest finished jobs while job workdirs and logs take up more than this, e.g. 20GB",
+				},
+				cli.StringFlag{
+					Name:  "janitor-interval",
+					Value: "",
+					Usage: "how often the retention policy is enforced (default 10m)",
+				},
+				cli.StringFlag{
+					Name:  "ready-min-free-disk",
+					Value: "",
+					Usage: "free space the readiness check expects in the temp, data and clone cache dirs, e.g. 5GB (default 1GB)",
+				},
+				cli.StringFlag{
+					Name:  "git-credentials",
+					Value: "",
+					Usage: "JSON file of per-host credentials (including SSH keys) used for cloning",
+				},
+				cli.StringFlag{
+					Name:  "clone-cache-dir",
+					Value: "",
+					Usage: "directory in which clones are cached between jobs",
+				},
+				cli.StringFlag{
+					Name:  "clone-cache",
+					Value: "",
+					Usage: "how the clone cache is used: no, create, if_available or force (default no)",
+				},
+				cli.StringSliceFlag{
+					Name:  "notify-url",
+					Value: &cli.StringSlice{},
+					Usage: "URL to POST the job to whenever its status changes (may be given more than once)",
+				},
+				cli.StringFlag{
+					Name:  "notify-secret",
+					Value: "",
+					Usage: "secret used to sign job notifications",
+				},
 				cli.StringFlag{
 					Name:  "username",
 					Value: "",
@@ -184,6 +288,11 @@ func main() {
 					Value: "",
 					Usage: "password for basic auth",
 				},
+				cli.StringFlag{
+					Name:  "auth-tokens",
+					Value: "",
+					Usage: "JSON file of named API tokens with scopes, which is created if it doesn't exist (turns on token auth)",
+				},
 				cli.StringFlag{
 					Name:  "travis-token",
 					Value: "",
@@ -194,6 +303,16 @@ func main() {
 					Value: "",
 					Usage: "GitHub secret for webhooks",
 				},
+				cli.StringFlag{
+					Name:  "github-api-url",
+					Value: "",
+					Usage: "base URL of the GitHub API used for commit statuses (default https://api.github.com)",
+				},
+				cli.StringFlag{
+					Name:  "ref-rules",
+					Value: "",
+					Usage: "JSON file of rules deciding which branches and tags are built from webhooks",
+				},
 				cli.BoolFlag{
 					Name:  "no-travis",
 					Usage: "do not include route for Travis CI webhook",
@@ -202,6 +321,33 @@ func main() {
 					Name:  "no-github",
 					Usage: "do not include route for GitHub webhook",
 				},
+				cli.StringFlag{
+					Name:  "gitlab-token",
+					Value: "",
+					Usage: "GitLab secret token for webhooks (the GitLab route is only included when set)",
+				},
+				cli.BoolFlag{
+					Name:  "no-gitlab",
+					Usage: "do not include route for GitLab webhook",
+				},
+				cli.StringFlag{
+					Name:  "bitbucket-secret",
+					Value: "",
+					Usage: "Bitbucket secret for webhooks (the Bitbucket route is only included when set)",
+				},
+				cli.BoolFlag{
+					Name:  "no-bitbucket",
+					Usage: "do not include route for Bitbucket webhook",
+				},
+				cli.StringFlag{
+					Name:  "gitea-secret",
+					Value: "",
+					Usage: "Gitea secret for webhooks (the Gitea route is only included when set)",
+				},
+				cli.BoolFlag{
+					Name:  "no-gitea",
+					Usage: "do not include route for Gitea webhook",
+				},
 			},
 		},
 	}
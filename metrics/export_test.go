@@ -0,0 +1,10 @@
+package metrics
+
+// Reset unregisters every metric, so that the specs can register theirs again
+// when they're run more than once
+func Reset() {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	registry = map[string]metric{}
+}
@@ -0,0 +1,301 @@
+/*
+Package metrics keeps track of the build server's metrics and exposes them in
+the Prometheus text format (version 0.0.4).  It only implements the handful of
+metric types the server needs: counters, gauges whose value is computed when
+the metrics are scraped, and histograms.
+*/
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the Prometheus text format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	// DurationBuckets are the histogram buckets (in seconds) for the steps of
+	// a job, which take anywhere from a second to an hour
+	DurationBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200, 1800, 3600}
+
+	// LatencyBuckets are the histogram buckets (in seconds) for HTTP requests
+	LatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+// metric is anything that can write itself in the text format
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	registry     = map[string]metric{}
+	registryLock sync.Mutex
+)
+
+func register(m metric) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if _, ok := registry[m.name()]; ok {
+		panic("metrics: " + m.name() + " is already registered")
+	}
+	registry[m.name()] = m
+}
+
+/*
+series is the set of values of a metric that has labels, one per combination
+of label values
+*/
+type series struct {
+	metricName string
+	help       string
+	labels     []string
+	lock       sync.Mutex
+}
+
+func (s *series) name() string { return s.metricName }
+
+// key joins label values into a map key, checking that there are as many as
+// there are labels
+func (s *series) key(values []string) string {
+	if len(values) != len(s.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", s.metricName, len(s.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs formats the labels for the series with the given key, along with
+// any extra pairs (e.g. a histogram's le)
+func (s *series) labelPairs(key string, extra ...string) string {
+	var pairs []string
+	if len(s.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, s.labels[i]+`="`+escape(value)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escape(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (s *series) header(w io.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", s.metricName, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", s.metricName, kind)
+}
+
+// Counter is a metric that only goes up, e.g. the number of jobs created
+type Counter struct {
+	series
+	values map[string]float64
+}
+
+// NewCounter creates and registers a counter with the given labels
+func NewCounter(name, help string, labels ...string) *Counter {
+	ret := &Counter{
+		series: series{metricName: name, help: help, labels: labels},
+		values: map[string]float64{},
+	}
+	register(ret)
+	return ret
+}
+
+// Inc adds one to the counter for the given label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v (which must not be negative) to the counter for the given label values
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := c.key(labelValues)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.values[key] += v
+}
+
+// Value returns the counter's value for the given label values
+func (c *Counter) Value(labelValues ...string) float64 {
+	key := c.key(labelValues)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.values[key]
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.header(w, "counter")
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, c.labelPairs(key), formatFloat(c.values[key]))
+	}
+}
+
+// GaugeFunc is a metric whose value is computed by calling a func whenever the
+// metrics are scraped, e.g. the length of the job queue
+type GaugeFunc struct {
+	series
+	fn func() float64
+}
+
+// NewGaugeFunc creates and registers a gauge whose value is fn()
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	ret := &GaugeFunc{series: series{metricName: name, help: help}, fn: fn}
+	register(ret)
+	return ret
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.header(w, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatFloat(g.fn()))
+}
+
+// Histogram is a metric that counts observations (e.g. durations) in buckets
+type Histogram struct {
+	series
+	buckets []float64
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+/*
+NewHistogram creates and registers a histogram with the given (sorted) bucket
+upper bounds and labels.  The +Inf bucket is always added.
+*/
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	ret := &Histogram{
+		series:  series{metricName: name, help: help, labels: labels},
+		buckets: append([]float64{}, buckets...),
+		values:  map[string]*histogramValue{},
+	}
+	register(ret)
+	return ret
+}
+
+// Observe adds v to the histogram for the given label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	value, ok := h.values[key]
+	if !ok {
+		value = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = value
+	}
+
+	for i, bound := range h.buckets {
+		if v <= bound {
+			value.counts[i]++
+		}
+	}
+	value.count++
+	value.sum += v
+}
+
+// Count returns the number of observations for the given label values
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	key := h.key(labelValues)
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if value, ok := h.values[key]; ok {
+		return value.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.header(w, "histogram")
+
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := h.values[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labelPairs(key, "le", formatFloat(bound)), value.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labelPairs(key, "le", "+Inf"), value.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, h.labelPairs(key), formatFloat(value.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, h.labelPairs(key), value.count)
+	}
+}
+
+// Write writes every registered metric to w in the text format, sorted by name
+func Write(w io.Writer) {
+	registryLock.Lock()
+	var metrics []metric
+	for _, m := range registry {
+		metrics = append(metrics, m)
+	}
+	registryLock.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name() < metrics[j].name() })
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler is the handler function for the metrics route
+func Handler(w http.ResponseWriter, req *http.Request) {
+	var buf bytes.Buffer
+	Write(&buf)
+
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
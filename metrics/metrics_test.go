@@ -0,0 +1,91 @@
+package metrics_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rafecolton/docker-builder/metrics"
+)
+
+var _ = Describe("metrics", func() {
+	scrape := func() string {
+		var buf bytes.Buffer
+		Write(&buf)
+		return buf.String()
+	}
+
+	BeforeEach(func() {
+		Reset()
+	})
+
+	It("writes counters for each combination of label values", func() {
+		c := NewCounter("test_jobs_total", "Jobs.", "repo", "trigger")
+		c.Inc("foo/bar", "github")
+		c.Inc("foo/bar", "github")
+		c.Add(3, "foo/baz", "api")
+
+		Expect(c.Value("foo/bar", "github")).To(Equal(2.0))
+		Expect(scrape()).To(ContainSubstring(
+			"# HELP test_jobs_total Jobs.\n" +
+				"# TYPE test_jobs_total counter\n" +
+				"test_jobs_total{repo=\"foo/bar\",trigger=\"github\"} 2\n" +
+				"test_jobs_total{repo=\"foo/baz\",trigger=\"api\"} 3\n",
+		))
+	})
+
+	It("escapes label values", func() {
+		c := NewCounter("test_escaped_total", "Escaped.", "path")
+		c.Inc("a\"b\\c\nd")
+
+		Expect(scrape()).To(ContainSubstring(`test_escaped_total{path="a\"b\\c\nd"} 1`))
+	})
+
+	It("computes gauges when scraped", func() {
+		value := 1.0
+		NewGaugeFunc("test_depth", "Depth.", func() float64 { return value })
+
+		Expect(scrape()).To(ContainSubstring("# TYPE test_depth gauge\ntest_depth 1\n"))
+		value = 5
+		Expect(scrape()).To(ContainSubstring("test_depth 5\n"))
+	})
+
+	It("writes cumulative histogram buckets along with the sum and count", func() {
+		h := NewHistogram("test_duration_seconds", "Durations.", []float64{1, 10}, "step")
+		h.Observe(0.5, "clone")
+		h.Observe(5, "clone")
+		h.Observe(50, "clone")
+
+		Expect(h.Count("clone")).To(Equal(uint64(3)))
+		Expect(scrape()).To(ContainSubstring(
+			"# TYPE test_duration_seconds histogram\n" +
+				"test_duration_seconds_bucket{step=\"clone\",le=\"1\"} 1\n" +
+				"test_duration_seconds_bucket{step=\"clone\",le=\"10\"} 2\n" +
+				"test_duration_seconds_bucket{step=\"clone\",le=\"+Inf\"} 3\n" +
+				"test_duration_seconds_sum{step=\"clone\"} 55.5\n" +
+				"test_duration_seconds_count{step=\"clone\"} 3\n",
+		))
+	})
+
+	It("refuses to register the same metric twice", func() {
+		NewCounter("test_twice_total", "Twice.")
+		Expect(func() { NewCounter("test_twice_total", "Twice.") }).To(Panic())
+	})
+
+	It("refuses the wrong number of label values", func() {
+		c := NewCounter("test_labels_total", "Labels.", "repo")
+		Expect(func() { c.Inc() }).To(Panic())
+	})
+
+	It("serves the metrics in the text format", func() {
+		NewCounter("test_served_total", "Served.").Inc()
+
+		recorder := httptest.NewRecorder()
+		Handler(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+		Expect(recorder.Code).To(Equal(200))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal(ContentType))
+		Expect(recorder.Body.String()).To(ContainSubstring("test_served_total 1\n"))
+	})
+})
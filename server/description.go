@@ -18,11 +18,38 @@ Server:
   DOCKER_BUILDER_PORT             =>     --port
   DOCKER_BUILDER_APITOKEN         =>     --api-token
   DOCKER_BUILDER_SKIPPUSH         =>     --skip-push
+  DOCKER_BUILDER_WORKERS          =>     --workers
+  DOCKER_BUILDER_REPOCONCURRENCY  =>     --repo-concurrency
+  DOCKER_BUILDER_DEDUPJOBS        =>     --dedup-jobs
+  DOCKER_BUILDER_JOBTIMEOUT       =>     --job-timeout
+  DOCKER_BUILDER_RETRYATTEMPTS    =>     --retry-attempts
+  DOCKER_BUILDER_RETRYBACKOFF     =>     --retry-backoff
+  DOCKER_BUILDER_DATADIR          =>     --data-dir
+  DOCKER_BUILDER_REFRULES         =>     --ref-rules
+  DOCKER_BUILDER_GITCREDENTIALS   =>     --git-credentials
+  DOCKER_BUILDER_CLONECACHEDIR    =>     --clone-cache-dir
+  DOCKER_BUILDER_CLONECACHE       =>     --clone-cache
+
+Job Retention:
+  DOCKER_BUILDER_RETENTIONMAXAGE  =>     --retention-max-age
+  DOCKER_BUILDER_RETENTIONMAXJOBS =>     --retention-max-jobs
+  DOCKER_BUILDER_RETENTIONMAXDISK =>     --retention-max-disk
+  DOCKER_BUILDER_JANITORINTERVAL  =>     --janitor-interval
+
+Readiness:
+  DOCKER_BUILDER_READYMINFREEDISK =>     --ready-min-free-disk
+
+Job Notifications:
+  DOCKER_BUILDER_NOTIFYURLS       =>     --notify-url
+  DOCKER_BUILDER_NOTIFYSECRET     =>     --notify-secret
 
 Basic Auth:
   DOCKER_BUILDER_USERNAME         =>     --username
   DOCKER_BUILDER_PASSWORD         =>     --password
 
+Token Auth:
+  DOCKER_BUILDER_AUTHTOKENS       =>     --auth-tokens
+
 Travis Auth:
   DOCKER_BUILDER_TRAVISTOKEN      =>     --travis-token
   DOCKER_BUILDER_NOTRAVIS         =>     --no-travis
@@ -30,6 +57,25 @@ Travis Auth:
 GitHub Auth:
   DOCKER_BUILDER_GITHUBSECRET     =>     --github-secret
   DOCKER_BUILDER_NOGITHUB         =>     --no-github
+  DOCKER_BUILDER_GITHUBAPIURL     =>     --github-api-url
+
+GitLab Auth:
+  DOCKER_BUILDER_GITLABTOKEN      =>     --gitlab-token
+  DOCKER_BUILDER_NOGITLAB         =>     --no-gitlab
+
+Bitbucket Auth:
+  DOCKER_BUILDER_BITBUCKETSECRET  =>     --bitbucket-secret
+  DOCKER_BUILDER_NOBITBUCKET      =>     --no-bitbucket
+
+Gitea Auth:
+  DOCKER_BUILDER_GITEASECRET      =>     --gitea-secret
+  DOCKER_BUILDER_NOGITEA          =>     --no-gitea
 
 NOTE: If username and password are both empty (i.e. not provided), basic auth will not be used.
+
+NOTE: With token auth, the basic auth username and password (if provided) may still be used, with no limits.
+
+NOTE: If no data dir is provided, job history is kept in memory only and is lost when the server restarts.
+
+NOTE: DOCKER_BUILDER_NOTIFYURLS is a comma-separated list, and --notify-url may be given more than once.
 `
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/rafecolton/docker-builder/metrics"
+)
+
+var (
+	httpRequests = metrics.NewCounter(
+		"docker_builder_http_requests_total",
+		"HTTP requests, by method, route and response code.",
+		"method", "route", "code",
+	)
+
+	httpLatency = metrics.NewHistogram(
+		"docker_builder_http_request_duration_seconds",
+		"How long HTTP requests take, by method and route.",
+		metrics.LatencyBuckets,
+		"method", "route",
+	)
+
+	// the routes under JobRoute+"/:id"
+	jobSubroutes = map[string]bool{"tail": true, "stream": true, "events": true, "retry": true}
+)
+
+/*
+routeLabel is the value of the route label for a request for path.  Job ids
+are replaced with :id, and paths that aren't routes are all labeled "other" so
+that requests for random paths don't create new series.
+*/
+func routeLabel(path string) string {
+	switch path {
+	case BuildRoute, BitbucketRoute, GiteaRoute, GitHubRoute, GitLabRoute, TravisRoute,
+		HealthRoute, MetricsRoute, JobRoute, AdminRoute + "/janitor", AdminRoute + "/tokens":
+		return path
+	}
+
+	if strings.HasPrefix(path, AdminRoute+"/tokens/") {
+		return AdminRoute + "/tokens/:name"
+	}
+
+	if strings.HasPrefix(path, JobRoute+"/") {
+		parts := strings.Split(strings.TrimPrefix(path, JobRoute+"/"), "/")
+		switch {
+		case len(parts) == 1 && parts[0] != "":
+			return JobRoute + "/:id"
+		case len(parts) == 2 && jobSubroutes[parts[1]]:
+			return JobRoute + "/:id/" + parts[1]
+		}
+	}
+
+	return "other"
+}
@@ -2,15 +2,20 @@ package server
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/rafecolton/docker-builder/job"
+	"github.com/rafecolton/docker-builder/metrics"
 	"github.com/rafecolton/docker-builder/server/webhook"
+	"github.com/rafecolton/docker-builder/tokens"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
+	"github.com/docker/go-units"
 	"github.com/go-martini/martini"
 	"github.com/martini-contrib/auth"
+	"github.com/modcloth/kamino"
 	"github.com/rafecolton/vauth"
 )
 
@@ -18,15 +23,33 @@ const (
 	// BuildRoute is the route used to POST docker builds from JSON
 	BuildRoute = "/docker-build"
 
+	// BitbucketRoute is the route for Bitbucket webhooks
+	BitbucketRoute = "/docker-build/bitbucket"
+
+	// GiteaRoute is the route for Gitea webhooks
+	GiteaRoute = "/docker-build/gitea"
+
 	// GitHubRoute is the route for GitHub webhooks
 	GitHubRoute = "/docker-build/github"
 
+	// GitLabRoute is the route for GitLab webhooks
+	GitLabRoute = "/docker-build/gitlab"
+
+	// AdminRoute is the route for server administration (see docs for more info)
+	AdminRoute = "/admin"
+
 	// HealthRoute is the route for health checks
 	HealthRoute = "/health"
 
 	// JobRoute is the route for job operations (various routes, see docs for more info)
 	JobRoute = "/jobs"
 
+	// ReadyRoute is the route for readiness checks
+	ReadyRoute = "/ready"
+
+	// MetricsRoute is the route for Prometheus metrics
+	MetricsRoute = "/metrics"
+
 	// TravisRoute is the route for TravisCI webhooks
 	TravisRoute = "/docker-build/travis"
 )
@@ -34,7 +57,9 @@ const (
 var logger *logrus.Logger
 var server *martini.ClassicMartini
 var skipLogging = map[string]bool{
-	"/health": true,
+	"/health":  true,
+	"/metrics": true,
+	"/ready":   true,
 }
 
 //Logger sets the (global) logger for the server package
@@ -53,16 +78,116 @@ func Serve(context *cli.Context) {
 	} else {
 		basicAuthFunc = func(http.ResponseWriter, *http.Request) {}
 	}
+	if authTokensFile != "" {
+		if err := tokens.Configure(authTokensFile); err != nil {
+			logger.WithField("error", err).Fatal("unable to load auth tokens")
+		}
+	}
 	if shouldTravisAuth {
 		travisAuthFunc = vauth.TravisCI(travisToken)
 	}
 	if shouldGitHubAuth {
 		githubAuthFunc = vauth.GitHub(githubSecret)
 	}
+	if shouldGitLabAuth {
+		gitlabAuthFunc = webhook.GitLabAuth(gitlabToken)
+	}
+	if shouldBitbucketAuth {
+		bitbucketAuthFunc = webhook.BitbucketAuth(bitbucketSecret)
+	}
+	if shouldGiteaAuth {
+		giteaAuthFunc = webhook.GiteaAuth(giteaSecret)
+	}
+
+	// before anything in the job package starts logging (the janitor and the
+	// workers log from their own goroutines)
+	job.Logger(logger)
+
+	// configure job persistence
+	if dataDir != "" {
+		if err := job.OpenDataDir(dataDir); err != nil {
+			logger.WithField("error", err).Fatal("unable to open data dir")
+		}
+	}
+
+	// configure clone credentials
+	if gitCredentialsFile != "" {
+		creds, err := job.LoadCredentials(gitCredentialsFile)
+		if err != nil {
+			logger.WithField("error", err).Fatal("unable to load git credentials")
+		}
+		job.SetCredentials(creds)
+	}
+
+	// configure clone caching
+	if err := job.SetCloneCache(cloneCacheDir, kamino.CacheOption(cloneCache)); err != nil {
+		logger.WithField("error", err).Fatal("unable to configure clone cache")
+	}
+
+	// configure job notifications
+	if len(notifyURLs) > 0 {
+		job.SetNotifier(&job.Notifier{
+			URLs:   notifyURLs,
+			Secret: notifySecret,
+			Logger: logger,
+		})
+	}
+
+	// configure job timeouts
+	if jobTimeout != "" {
+		timeout, err := time.ParseDuration(jobTimeout)
+		if err != nil || timeout < 0 {
+			logger.WithField("job_timeout", jobTimeout).Fatal("invalid job timeout")
+		}
+		job.DefaultTimeout = timeout
+	}
+
+	// configure retries for clones and pushes
+	retryPolicy := job.RetryPolicy{Attempts: retryAttempts}
+	if retryBackoff != "" {
+		backoff, err := time.ParseDuration(retryBackoff)
+		if err != nil || backoff <= 0 {
+			logger.WithField("retry_backoff", retryBackoff).Fatal("invalid retry backoff")
+		}
+		retryPolicy.Backoff = backoff
+	}
+	if retryAttempts < 0 {
+		logger.WithField("retry_attempts", retryAttempts).Fatal("invalid retry attempts")
+	}
+	job.SetRetryPolicy(retryPolicy)
+
+	// configure readiness checks
+	if readyMinFreeDisk != "" {
+		bytes, err := units.RAMInBytes(readyMinFreeDisk)
+		if err != nil || bytes <= 0 {
+			logger.WithField("ready_min_free_disk", readyMinFreeDisk).Fatal("invalid readiness minimum free disk")
+		}
+		job.SetReadyMinFreeDisk(bytes)
+	}
+
+	// start processing async jobs
+	job.SetRepoConcurrency(repoConcurrency)
+	job.StartWorkers(workers)
+
+	// start removing old jobs
+	if retention, ok, err := retentionPolicy(); err != nil {
+		logger.WithField("error", err).Fatal("invalid retention policy")
+	} else if ok {
+		job.StartJanitor(retention)
+	}
 
 	// configure webhooks
 	webhook.Logger(logger)
 	webhook.APIToken(apiToken)
+	webhook.DedupJobs(dedupJobs)
+	webhook.BasicAuth(un, pwd)
+	if refRulesFile != "" {
+		rules, err := webhook.LoadRefRules(refRulesFile)
+		if err != nil {
+			logger.WithField("error", err).Fatal("unable to load ref rules")
+		}
+		webhook.SetRefRules(rules)
+	}
 
 	server = setupServer()
 
@@ -72,18 +197,47 @@ func Serve(context *cli.Context) {
 	if shouldGitHub {
 		server.Post(GitHubRoute, githubAuthFunc, webhook.Github)
 	}
+	if shouldGitLab {
+		server.Post(GitLabRoute, gitlabAuthFunc, webhook.GitLab)
+	}
+	if shouldBitbucket {
+		server.Post(BitbucketRoute, bitbucketAuthFunc, webhook.Bitbucket)
+	}
+	if shouldGitea {
+		server.Post(GiteaRoute, giteaAuthFunc, webhook.Gitea)
+	}
 
 	// base routes
 	server.Get(HealthRoute, func() (int, string) { return 200, "200 OK" })
-	server.Post(BuildRoute, basicAuthFunc, webhook.DockerBuild)
+	server.Get(ReadyRoute, job.Ready)
+	server.Get(MetricsRoute, authFunc(tokens.ScopeMetrics), metrics.Handler)
+	server.Post(BuildRoute, authFunc(tokens.ScopeJobsWrite), webhook.DockerBuild)
 
 	// job control routes
+	read := authFunc(tokens.ScopeJobsRead)
+	write := authFunc(tokens.ScopeJobsWrite)
+	cancel := authFunc(tokens.ScopeJobsCancel)
 	server.Group(JobRoute, func(r martini.Router) {
-		r.Get("/:id", job.Get)
-		r.Get("/:id/tail", job.TailN)
-		r.Post("", webhook.DockerBuild)
-		r.Get("", job.GetAll)
-	}, basicAuthFunc)
+		r.Get("/:id", read, job.CheckAccess, job.Get)
+		r.Delete("/:id", cancel, job.CheckAccess, job.Cancel)
+		r.Get("/:id/tail", read, job.CheckAccess, job.TailN)
+		r.Get("/:id/stream", read, job.CheckAccess, job.Stream)
+		r.Get("/:id/events", read, job.CheckAccess, job.GetEvents)
+		r.Post("/:id/retry", write, job.CheckAccess, webhook.Retry)
+		r.Post("", write, webhook.DockerBuild)
+		r.Get("", read, job.GetAll)
+	})
+
+	// admin routes
+	server.Group(AdminRoute, func(r martini.Router) {
+		r.Get("/janitor", job.GetJanitor)
+		r.Post("/janitor", job.SweepNow)
+		if tokens.Enabled() {
+			r.Get("/tokens", tokens.List)
+			r.Post("/tokens", tokens.Create)
+			r.Delete("/tokens/:name", tokens.Revoke)
+		}
+	}, authFunc(tokens.ScopeAdmin))
 
 	// start server
 	http.ListenAndServe(portString, server)
@@ -119,5 +273,48 @@ func requestLogger(res http.ResponseWriter, req *http.Request, c martini.Context
 	rw := res.(martini.ResponseWriter)
 	c.Next()
 
-	logger.Printf("Completed %v %s in %v\n", rw.Status(), http.StatusText(rw.Status()), time.Since(start))
+	elapsed := time.Since(start)
+	route := routeLabel(req.URL.Path)
+	httpRequests.Inc(req.Method, route, strconv.Itoa(rw.Status()))
+	httpLatency.Observe(elapsed.Seconds(), req.Method, route)
+
+	logger.Printf("Completed %v %s in %v\n", rw.Status(), http.StatusText(rw.Status()), elapsed)
+}
+
+/*
+authFunc is the auth for a route that needs scope.  With token auth, that's a
+token with scope (or basic auth), and otherwise it's just basic auth (if it's
+configured).
+*/
+func authFunc(scope string) martini.Handler {
+	if tokens.Enabled() {
+		return tokens.Auth(scope, un, pwd)
+	}
+	return basicAuthFunc
+}
+
+/*
+retentionPolicy is the policy for removing old jobs, if there is one (ok is
+false if no limits are set).
+*/
+func retentionPolicy() (retention job.Retention, ok bool, err error) {
+	if retentionMaxAge != "" {
+		if retention.MaxAge, err = time.ParseDuration(retentionMaxAge); err != nil {
+			return retention, false, err
+		}
+	}
+	if retentionMaxDisk != "" {
+		if retention.MaxDisk, err = units.RAMInBytes(retentionMaxDisk); err != nil {
+			return retention, false, err
+		}
+	}
+	if janitorInterval != "" {
+		if retention.Interval, err = time.ParseDuration(janitorInterval); err != nil {
+			return retention, false, err
+		}
+	}
+	retention.MaxJobs = retentionMaxJobs
+
+	ok = retention.MaxAge > 0 || retention.MaxJobs > 0 || retention.MaxDisk > 0
+	return retention, ok, nil
 }
@@ -11,15 +11,19 @@ import (
 	"github.com/go-martini/martini"
 )
 
-var apiToken, githubSecret, portString, pwd, travisToken, un string
-var port int
-var skipPush bool
-var shouldTravis, shouldGitHub bool
-var shouldBasicAuth, shouldTravisAuth, shouldGitHubAuth bool
+var apiToken, authTokensFile, bitbucketSecret, cloneCache, cloneCacheDir, dataDir, janitorInterval, jobTimeout, readyMinFreeDisk, retentionMaxAge, retryBackoff, retentionMaxDisk, gitCredentialsFile, giteaSecret, githubAPIURL, githubSecret, gitlabToken, notifySecret, portString, pwd, refRulesFile, travisToken, un string
+var notifyURLs []string
+var port, repoConcurrency, retentionMaxJobs, retryAttempts, workers int
+var dedupJobs, skipPush bool
+var shouldTravis, shouldGitHub, shouldGitLab, shouldBitbucket, shouldGitea bool
+var shouldBasicAuth, shouldTravisAuth, shouldGitHubAuth, shouldGitLabAuth, shouldBitbucketAuth, shouldGiteaAuth bool
 
 var basicAuthFunc martini.Handler
 var travisAuthFunc = func(http.ResponseWriter, *http.Request) {}
 var githubAuthFunc = func(http.ResponseWriter, *http.Request) {}
+var gitlabAuthFunc = func(http.ResponseWriter, *http.Request) {}
+var bitbucketAuthFunc = func(http.ResponseWriter, *http.Request) {}
+var giteaAuthFunc = func(http.ResponseWriter, *http.Request) {}
 
 func setVarsFromContext(c *cli.Context) {
 	config := conf.Config
@@ -28,18 +32,62 @@ func setVarsFromContext(c *cli.Context) {
 	// ENV
 	un = config.Username
 	pwd = config.Password
+	authTokensFile = config.AuthTokens
 	apiToken = config.APIToken
 	travisToken = config.TravisToken
 	githubSecret = config.GitHubSecret
+	githubAPIURL = config.GitHubAPIURL
+	gitlabToken = config.GitLabToken
+	bitbucketSecret = config.BitbucketSecret
+	giteaSecret = config.GiteaSecret
+	refRulesFile = config.RefRules
+	gitCredentialsFile = config.GitCredentials
+	cloneCacheDir = config.CloneCacheDir
+	cloneCache = config.CloneCache
 	port = config.Port
+	workers = config.Workers
+	repoConcurrency = config.RepoConcurrency
+	jobTimeout = config.JobTimeout
+	retryAttempts = config.RetryAttempts
+	retryBackoff = config.RetryBackoff
+	dataDir = config.DataDir
+	retentionMaxAge = config.RetentionMaxAge
+	retentionMaxJobs = config.RetentionMaxJobs
+	retentionMaxDisk = config.RetentionMaxDisk
+	janitorInterval = config.JanitorInterval
+	notifyURLs = config.NotifyURLs
+	notifySecret = config.NotifySecret
+	readyMinFreeDisk = config.ReadyMinFreeDisk
 
 	// command line
 	cliUn := c.String("username")
 	cliPwd := c.String("password")
+	cliAuthTokensFile := c.String("auth-tokens")
 	cliAPIToken := c.String("api-token")
 	cliTravisToken := c.String("travis-token")
 	cliGitHubSecret := c.String("github-secret")
+	cliGitHubAPIURL := c.String("github-api-url")
+	cliGitLabToken := c.String("gitlab-token")
+	cliBitbucketSecret := c.String("bitbucket-secret")
+	cliGiteaSecret := c.String("gitea-secret")
+	cliRefRulesFile := c.String("ref-rules")
+	cliGitCredentialsFile := c.String("git-credentials")
+	cliCloneCacheDir := c.String("clone-cache-dir")
+	cliCloneCache := c.String("clone-cache")
 	cliPort := c.Int("port")
+	cliWorkers := c.Int("workers")
+	cliRepoConcurrency := c.Int("repo-concurrency")
+	cliJobTimeout := c.String("job-timeout")
+	cliRetryAttempts := c.Int("retry-attempts")
+	cliRetryBackoff := c.String("retry-backoff")
+	cliDataDir := c.String("data-dir")
+	cliRetentionMaxAge := c.String("retention-max-age")
+	cliRetentionMaxJobs := c.Int("retention-max-jobs")
+	cliRetentionMaxDisk := c.String("retention-max-disk")
+	cliJanitorInterval := c.String("janitor-interval")
+	cliNotifyURLs := c.StringSlice("notify-url")
+	cliNotifySecret := c.String("notify-secret")
+	cliReadyMinFreeDisk := c.String("ready-min-free-disk")
 
 	if cliTravisToken != "" {
 		travisToken = cliTravisToken
@@ -49,6 +97,38 @@ func setVarsFromContext(c *cli.Context) {
 		githubSecret = cliGitHubSecret
 	}
 
+	if cliGitHubAPIURL != "" {
+		githubAPIURL = cliGitHubAPIURL
+	}
+
+	if cliGitLabToken != "" {
+		gitlabToken = cliGitLabToken
+	}
+
+	if cliBitbucketSecret != "" {
+		bitbucketSecret = cliBitbucketSecret
+	}
+
+	if cliGiteaSecret != "" {
+		giteaSecret = cliGiteaSecret
+	}
+
+	if cliRefRulesFile != "" {
+		refRulesFile = cliRefRulesFile
+	}
+
+	if cliGitCredentialsFile != "" {
+		gitCredentialsFile = cliGitCredentialsFile
+	}
+
+	if cliCloneCacheDir != "" {
+		cloneCacheDir = cliCloneCacheDir
+	}
+
+	if cliCloneCache != "" {
+		cloneCache = cliCloneCache
+	}
+
 	// if username passed on command line, use cl one instead
 	if cliUn != "" {
 		un = cliUn
@@ -59,6 +139,11 @@ func setVarsFromContext(c *cli.Context) {
 		pwd = cliPwd
 	}
 
+	// get named API tokens
+	if cliAuthTokensFile != "" {
+		authTokensFile = cliAuthTokensFile
+	}
+
 	// get api token
 	if cliAPIToken != "" {
 		apiToken = cliAPIToken
@@ -69,23 +154,93 @@ func setVarsFromContext(c *cli.Context) {
 		port = cliPort
 	}
 
+	// set worker count
+	if cliWorkers != 0 {
+		workers = cliWorkers
+	}
+
+	// set per-repo concurrency
+	if cliRepoConcurrency != 0 {
+		repoConcurrency = cliRepoConcurrency
+	}
+
+	// get job timeout
+	if cliJobTimeout != "" {
+		jobTimeout = cliJobTimeout
+	}
+
+	// get retry policy
+	if cliRetryAttempts != 0 {
+		retryAttempts = cliRetryAttempts
+	}
+	if cliRetryBackoff != "" {
+		retryBackoff = cliRetryBackoff
+	}
+
+	// get data dir
+	if cliDataDir != "" {
+		dataDir = cliDataDir
+	}
+
+	// get retention policy
+	if cliRetentionMaxAge != "" {
+		retentionMaxAge = cliRetentionMaxAge
+	}
+	if cliRetentionMaxJobs != 0 {
+		retentionMaxJobs = cliRetentionMaxJobs
+	}
+	if cliRetentionMaxDisk != "" {
+		retentionMaxDisk = cliRetentionMaxDisk
+	}
+	if cliJanitorInterval != "" {
+		janitorInterval = cliJanitorInterval
+	}
+
+	// get notification urls and secret
+	if len(cliNotifyURLs) > 0 {
+		notifyURLs = cliNotifyURLs
+	}
+	if cliNotifySecret != "" {
+		notifySecret = cliNotifySecret
+	}
+
+	// get readiness check minimum free space
+	if cliReadyMinFreeDisk != "" {
+		readyMinFreeDisk = cliReadyMinFreeDisk
+	}
+
 	// get port
 	portString = fmt.Sprintf(":%d", port)
 
 	// get skip-push
 	skipPush = c.Bool("skip-push") || config.SkipPush
 
+	// get dedup-jobs
+	dedupJobs = c.Bool("dedup-jobs") || config.DedupJobs
+
 	// check if should travis
 	shouldTravis = !c.Bool("no-travis") && !config.NoTravis
 
 	// check if should github
 	shouldGitHub = !c.Bool("no-github") && !config.NoGitHub
 
+	// check if should gitlab, bitbucket and gitea (only with a secret, since
+	// their payloads say where to clone from)
+	shouldGitLab = !c.Bool("no-gitlab") && !config.NoGitLab && gitlabToken != ""
+	shouldBitbucket = !c.Bool("no-bitbucket") && !config.NoBitbucket && bitbucketSecret != ""
+	shouldGitea = !c.Bool("no-gitea") && !config.NoGitea && giteaSecret != ""
+
 	shouldBasicAuth = (un != "" && pwd != "")
 	shouldTravisAuth = (travisToken != "")
 	shouldGitHubAuth = (githubSecret != "")
+	shouldGitLabAuth = (gitlabToken != "")
+	shouldBitbucketAuth = (bitbucketSecret != "")
+	shouldGiteaAuth = (giteaSecret != "")
 
 	/// highest priority
 
 	job.SkipPush = skipPush
+	if githubAPIURL != "" {
+		job.GitHubAPIURL = githubAPIURL
+	}
 }
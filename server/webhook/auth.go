@@ -0,0 +1,67 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/rafecolton/vauth"
+)
+
+/*
+GitLabAuth returns a Handler that authenticates GitLab webhooks by comparing
+the X-Gitlab-Token header to the secret token configured for the hook.
+
+Writes a http.StatusUnauthorized if authentication fails
+*/
+func GitLabAuth(token string) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if !vauth.SecureCompare(req.Header.Get("X-Gitlab-Token"), token) {
+			http.Error(res, "Not Authorized", http.StatusUnauthorized)
+		}
+	}
+}
+
+/*
+GiteaAuth returns a Handler that authenticates Gitea webhooks by checking the
+X-Gitea-Signature header, which is the hex encoded HMAC-SHA256 of the body.
+
+Writes a http.StatusUnauthorized if authentication fails
+*/
+func GiteaAuth(secret string) http.HandlerFunc {
+	return hmacAuth(secret, "X-Gitea-Signature", "")
+}
+
+/*
+BitbucketAuth returns a Handler that authenticates Bitbucket webhooks by
+checking the X-Hub-Signature header, which is "sha256=" followed by the hex
+encoded HMAC-SHA256 of the body.
+
+Writes a http.StatusUnauthorized if authentication fails
+*/
+func BitbucketAuth(secret string) http.HandlerFunc {
+	return hmacAuth(secret, "X-Hub-Signature", "sha256=")
+}
+
+func hmacAuth(secret, header, prefix string) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			http.Error(res, "Not Authorized", http.StatusUnauthorized)
+			return
+		}
+
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		calculatedSignature := prefix + hex.EncodeToString(mac.Sum(nil))
+
+		if !vauth.SecureCompare(req.Header.Get(header), calculatedSignature) {
+			http.Error(res, "Not Authorized", http.StatusUnauthorized)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rafecolton/docker-builder/job"
+)
+
+var (
+	bitbucketSupportedEvents = map[string]bool{
+		"repo:push": true,
+	}
+)
+
+type bitbucketLink struct {
+	Href string `json:"href"`
+}
+
+type bitbucketRepository struct {
+	FullName string `json:"full_name"`
+	Links    struct {
+		HTML bitbucketLink `json:"html"`
+	} `json:"links"`
+}
+
+type bitbucketRefState struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Target struct {
+		Hash string `json:"hash"`
+	} `json:"target"`
+}
+
+type bitbucketPushPayload struct {
+	Repository bitbucketRepository `json:"repository"`
+	Push       struct {
+		Changes []struct {
+			New *bitbucketRefState `json:"new"`
+		} `json:"changes"`
+	} `json:"push"`
+}
+
+/*
+Bitbucket parses a Bitbucket push webhook HTTP request and returns a job.Spec.
+A push may update several refs, but only the first one that wasn't deleted is
+built (the others are logged).  The repo is cloned over HTTPS from the
+repository's web URL.
+*/
+func Bitbucket(w http.ResponseWriter, req *http.Request) (int, string) {
+	event := req.Header.Get("X-Event-Key")
+	if !bitbucketSupportedEvents[event] {
+		return ignored("event " + event + " is not built")
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		logger.Error(err)
+		return http.StatusBadRequest, fmt.Sprintf("%d bad request", http.StatusBadRequest)
+	}
+
+	var payload = &bitbucketPushPayload{}
+	if err := json.Unmarshal(body, payload); err != nil {
+		logger.Error(err)
+		return http.StatusBadRequest, fmt.Sprintf("%d bad request", http.StatusBadRequest)
+	}
+
+	parts := strings.SplitN(payload.Repository.FullName, "/", 2)
+	if len(parts) != 2 {
+		logger.Errorf("Bitbucket repository name %q has no owner", payload.Repository.FullName)
+		return http.StatusBadRequest, fmt.Sprintf("%d bad request", http.StatusBadRequest)
+	}
+
+	var ref *bitbucketRefState
+	var skipped []string
+	for _, change := range payload.Push.Changes {
+		switch {
+		case change.New == nil:
+		case ref == nil:
+			ref = change.New
+		default:
+			skipped = append(skipped, change.New.Name)
+		}
+	}
+	if ref == nil {
+		return ignored("push only deleted refs")
+	}
+	if len(skipped) > 0 {
+		logger.WithFields(logrus.Fields{
+			"repo":    payload.Repository.FullName,
+			"built":   ref.Name,
+			"skipped": strings.Join(skipped, ","),
+		}).Warn("Bitbucket push updated several refs, only the first one is built")
+	}
+
+	spec := &job.Spec{
+		RepoOwner: parts[0],
+		RepoName:  parts[1],
+		GitRef:    ref.Target.Hash,
+		Trigger:   job.TriggerBitbucket,
+	}
+	if href := payload.Repository.Links.HTML.Href; href != "" {
+		spec.CloneURL = strings.TrimSuffix(href, "/") + ".git"
+	}
+	if err := checkCloneURL(spec.CloneURL); err != nil {
+		logger.WithField("error", err).Error("Bitbucket payload has no usable clone URL")
+		return http.StatusBadRequest, fmt.Sprintf("%d bad request", http.StatusBadRequest)
+	}
+
+	var kind string
+	switch ref.Type {
+	case "branch", "named_branch":
+		kind = RefKindBranch
+	case "tag", "annotated_tag":
+		kind = RefKindTag
+		spec.GitRef = ref.Name
+	}
+
+	if code, body, ok := filterRef(spec, kind, ref.Name); !ok {
+		return code, body
+	}
+
+	return processJobHelper(spec, w, req)
+}
@@ -0,0 +1,79 @@
+package webhook_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rafecolton/docker-builder/server/webhook"
+
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+)
+
+func makeBitbucketRequest(event, body string) *http.Request {
+	req, _ := http.NewRequest(
+		"POST",
+		"http://localhost:5000/docker-build/bitbucket",
+		bytes.NewReader([]byte(body)),
+	)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("X-Event-Key", event)
+	return req
+}
+
+var _ = Describe("Bitbucket", func() {
+	const repository = `"repository": {
+		"full_name": "testuser/testrepo",
+		"links": {"html": {"href": "https://bitbucket.org/testuser/testrepo"}}
+	}`
+
+	var serve = func(req *http.Request) *httptest.ResponseRecorder {
+		var recorder = httptest.NewRecorder()
+		newTestServer().ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	It("accepts pushes", func() {
+		recorder := serve(makeBitbucketRequest("repo:push", `{
+			`+repository+`,
+			"push": {"changes": [
+				{"new": null},
+				{"new": {"type": "branch", "name": "master", "target": {"hash": "a427f16faa8e"}}}
+			]}
+		}`))
+
+		Expect(recorder.Code).To(Equal(202))
+	})
+
+	It("ignores pushes that only delete refs", func() {
+		recorder := serve(makeBitbucketRequest("repo:push", `{`+repository+`, "push": {"changes": [{"new": null}]}}`))
+
+		Expect(recorder.Code).To(Equal(200))
+		Expect(recorder.Body.String()).To(ContainSubstring(`"ignored":true`))
+	})
+
+	It("ignores unsupported events", func() {
+		recorder := serve(makeBitbucketRequest("repo:fork", `{}`))
+		Expect(recorder.Code).To(Equal(200))
+		Expect(recorder.Body.String()).To(ContainSubstring(`"ignored":true`))
+	})
+
+	Context("when authenticating", func() {
+		It("only lets through requests with the right signature", func() {
+			const body = `{"push": {}}`
+			auth := BitbucketAuth("s3cr3t")
+
+			req := makeBitbucketRequest("repo:push", body)
+			req.Header.Set("X-Hub-Signature", "sha256="+hmacSHA256("s3cr3t", body))
+			recorder := httptest.NewRecorder()
+			auth(recorder, req)
+			Expect(recorder.Code).To(Equal(200))
+
+			req = makeBitbucketRequest("repo:push", body)
+			req.Header.Set("X-Hub-Signature", hmacSHA256("s3cr3t", body))
+			recorder = httptest.NewRecorder()
+			auth(recorder, req)
+			Expect(recorder.Code).To(Equal(401))
+		})
+	})
+})
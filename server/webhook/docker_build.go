@@ -4,14 +4,25 @@ import (
 	"io/ioutil"
 	"net/http"
 
+	"github.com/go-martini/martini"
+
 	"github.com/rafecolton/docker-builder/job"
 )
 
 /*
-DockerBuild parses a simple JSON blob returns a job.Spec
+DockerBuild parses a simple JSON blob returns a job.Spec.  The build context
+may instead be uploaded along with the spec (see uploadSpec).
 */
 func DockerBuild(w http.ResponseWriter, req *http.Request) (int, string) {
-	// TODO: check content type
+	spec, err := uploadSpec(w, req)
+	if err != nil {
+		logger.WithField("error", err).Error("error reading upload")
+		return 400, "400 bad request"
+	}
+	if spec != nil {
+		spec.Trigger = job.TriggerAPI
+		return processJobHelper(spec, w, req)
+	}
 
 	body, err := ioutil.ReadAll(req.Body)
 	defer req.Body.Close()
@@ -19,10 +30,29 @@ func DockerBuild(w http.ResponseWriter, req *http.Request) (int, string) {
 		return 400, "400 bad request"
 	}
 
-	spec, err := job.NewSpec(body)
+	spec, err = job.NewSpec(body)
 	if err != nil {
 		return 400, "400 bad request"
 	}
+	spec.Trigger = job.TriggerAPI
+
+	return processJobHelper(spec, w, req)
+}
+
+/*
+Retry creates a new job from the spec of the job with the requested id, e.g. to
+rebuild after an infrastructure hiccup.  The new job is always async.
+*/
+func Retry(params martini.Params, w http.ResponseWriter, req *http.Request) (int, string) {
+	original := job.Find(params["id"])
+	if original == nil {
+		return 404, `{"error": "job not found"}`
+	}
+
+	spec, err := original.RetrySpec()
+	if err != nil {
+		return 409, `{"error": "` + err.Error() + `"}`
+	}
 
 	return processJobHelper(spec, w, req)
 }
@@ -0,0 +1,100 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/rafecolton/docker-builder/job"
+)
+
+var (
+	giteaSupportedEvents = map[string]bool{
+		"create": true,
+		"push":   true,
+	}
+)
+
+type giteaOwner struct {
+	Login    string `json:"login"`
+	Username string `json:"username"`
+}
+
+type giteaRepository struct {
+	Name     string     `json:"name"`
+	Owner    giteaOwner `json:"owner"`
+	CloneURL string     `json:"clone_url"`
+}
+
+// giteaPayload covers both push and create events, which look like GitHub's
+type giteaPayload struct {
+	Repository giteaRepository `json:"repository"`
+	After      string          `json:"after"`
+	Ref        string          `json:"ref"`
+	RefType    string          `json:"ref_type"`
+}
+
+/*
+Gitea parses a Gitea webhook HTTP request and returns a job.Spec.  As with
+GitHub, pushes to branches and the creation of tags are built, and the repo is
+cloned from the repository's clone URL.
+*/
+func Gitea(w http.ResponseWriter, req *http.Request) (int, string) {
+	event := req.Header.Get("X-Gitea-Event")
+	if !giteaSupportedEvents[event] {
+		return ignored("event " + event + " is not built")
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		logger.Error(err)
+		return http.StatusBadRequest, fmt.Sprintf("%d bad request", http.StatusBadRequest)
+	}
+
+	var payload = &giteaPayload{}
+	if err := json.Unmarshal(body, payload); err != nil {
+		logger.Error(err)
+		return http.StatusBadRequest, fmt.Sprintf("%d bad request", http.StatusBadRequest)
+	}
+
+	owner := payload.Repository.Owner.Login
+	if owner == "" {
+		owner = payload.Repository.Owner.Username
+	}
+
+	spec := &job.Spec{
+		RepoOwner: owner,
+		RepoName:  payload.Repository.Name,
+		GitRef:    payload.After,
+		CloneURL:  payload.Repository.CloneURL,
+		Trigger:   job.TriggerGitea,
+	}
+	if err := checkCloneURL(spec.CloneURL); err != nil {
+		logger.WithField("error", err).Error("Gitea payload has no usable clone URL")
+		return http.StatusBadRequest, fmt.Sprintf("%d bad request", http.StatusBadRequest)
+	}
+
+	var kind, name string
+	switch event {
+	case "push":
+		if kind, name = parseRef(payload.Ref); kind == RefKindTag {
+			// Gitea sends a create event for the same tag, which is what gets built
+			return ignored("tags are built from create events")
+		}
+	case "create":
+		if payload.RefType != "tag" {
+			// new branches are built from the push event that comes with them
+			return ignored("only tags are built from create events")
+		}
+		kind, name = RefKindTag, payload.Ref
+		spec.GitRef = payload.Ref
+	}
+
+	if code, body, ok := filterRef(spec, kind, name); !ok {
+		return code, body
+	}
+
+	return processJobHelper(spec, w, req)
+}
@@ -0,0 +1,111 @@
+package webhook_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rafecolton/docker-builder/server/webhook"
+
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+)
+
+func makeGiteaRequest(event, body string) *http.Request {
+	req, _ := http.NewRequest(
+		"POST",
+		"http://localhost:5000/docker-build/gitea",
+		bytes.NewReader([]byte(body)),
+	)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("X-Gitea-Event", event)
+	return req
+}
+
+func hmacSHA256(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+var _ = Describe("Gitea", func() {
+	const repository = `"repository": {
+		"name": "testrepo",
+		"owner": {"login": "testuser"},
+		"clone_url": "https://gitea.example.com/testuser/testrepo.git"
+	}`
+
+	var serve = func(req *http.Request) *httptest.ResponseRecorder {
+		var recorder = httptest.NewRecorder()
+		newTestServer().ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	It("accepts pushes to branches", func() {
+		recorder := serve(makeGiteaRequest("push", `{
+			"ref": "refs/heads/master",
+			"after": "a427f16faa8e4d63f9fcaa4ec55e80765fd11b04",
+			`+repository+`
+		}`))
+
+		Expect(recorder.Code).To(Equal(202))
+	})
+
+	It("accepts tag creation", func() {
+		recorder := serve(makeGiteaRequest("create", `{"ref": "v1.0.0", "ref_type": "tag", `+repository+`}`))
+
+		Expect(recorder.Code).To(Equal(202))
+	})
+
+	It("ignores tag pushes and branch creation", func() {
+		Expect(serve(makeGiteaRequest("push", `{"ref": "refs/tags/v1.0.0", `+repository+`}`)).Code).To(Equal(200))
+		Expect(serve(makeGiteaRequest("create", `{"ref": "foo", "ref_type": "branch", `+repository+`}`)).Code).To(Equal(200))
+	})
+
+	It("ignores unsupported events", func() {
+		recorder := serve(makeGiteaRequest("issues", `{}`))
+		Expect(recorder.Code).To(Equal(200))
+		Expect(recorder.Body.String()).To(ContainSubstring(`"ignored":true`))
+	})
+
+	It("only clones from https and ssh URLs", func() {
+		for _, cloneURL := range []string{
+			"file:///etc/docker-builder/secrets.git",
+			"http://gitea.example.com/testuser/testrepo.git",
+			"-oProxyCommand=touch /tmp/pwned:foo/bar",
+		} {
+			Expect(serve(makeGiteaRequest("push", `{
+				"ref": "refs/heads/master",
+				"after": "a427f16faa8e4d63f9fcaa4ec55e80765fd11b04",
+				"repository": {"name": "testrepo", "owner": {"login": "testuser"}, "clone_url": "`+cloneURL+`"}
+			}`)).Code).To(Equal(400), cloneURL)
+		}
+
+		Expect(serve(makeGiteaRequest("push", `{
+			"ref": "refs/heads/master",
+			"after": "a427f16faa8e4d63f9fcaa4ec55e80765fd11b04",
+			"repository": {"name": "testrepo", "owner": {"login": "testuser"}, "clone_url": "ssh://git@gitea.example.com/testuser/testrepo.git"}
+		}`)).Code).To(Equal(202))
+	})
+
+	Context("when authenticating", func() {
+		It("only lets through requests with the right signature", func() {
+			const body = `{"ref": "refs/heads/master"}`
+			auth := GiteaAuth("s3cr3t")
+
+			req := makeGiteaRequest("push", body)
+			req.Header.Set("X-Gitea-Signature", hmacSHA256("s3cr3t", body))
+			recorder := httptest.NewRecorder()
+			auth(recorder, req)
+			Expect(recorder.Code).To(Equal(200))
+
+			req = makeGiteaRequest("push", body)
+			req.Header.Set("X-Gitea-Signature", hmacSHA256("wrong", body))
+			recorder = httptest.NewRecorder()
+			auth(recorder, req)
+			Expect(recorder.Code).To(Equal(401))
+		})
+	})
+})
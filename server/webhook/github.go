@@ -12,12 +12,14 @@ import (
 
 var (
 	githubSupportedEvents = map[string]bool{
-		"push": true,
+		"create": true,
+		"push":   true,
 	}
 )
 
 type githubOwner struct {
-	Name string `json:"name"`
+	Name  string `json:"name"`
+	Login string `json:"login"`
 }
 
 type githubRepository struct {
@@ -25,19 +27,27 @@ type githubRepository struct {
 	Owner githubOwner `json:"owner"`
 }
 
-type githubPushPayload struct {
+// githubPayload covers both push and create events.  For pushes, Ref is the
+// full ref name (e.g. refs/heads/master), while for creates it is the short
+// name, with RefType saying whether it is a branch or a tag.
+type githubPayload struct {
 	Repository githubRepository `json:"repository"`
 	CommitSHA  string           `json:"after"`
+	Deleted    bool             `json:"deleted"`
+	Ref        string           `json:"ref"`
+	RefType    string           `json:"ref_type"`
 }
 
 /*
-Github parses a Github webhook HTTP request and returns a job.Spec.
+Github parses a Github webhook HTTP request and returns a job.Spec.  Pushes to
+branches and the creation of tags are built (unless the ref rules say
+otherwise), while other refs and events are ignored.
 */
 func Github(w http.ResponseWriter, req *http.Request) (int, string) {
 	event := req.Header.Get("X-Github-Event")
 	if !githubSupportedEvents[event] {
-		logger.Errorf("Github event type %s is not supported.", event)
-		return http.StatusBadRequest, fmt.Sprintf("%d bad request", http.StatusBadRequest)
+		// GitHub sends ping, delete and other events to the same hook
+		return ignored("event " + event + " is not built")
 	}
 	body, err := ioutil.ReadAll(req.Body)
 	defer req.Body.Close()
@@ -48,16 +58,45 @@ func Github(w http.ResponseWriter, req *http.Request) (int, string) {
 	}
 
 	decoder := json.NewDecoder(bytes.NewReader(body))
-	var payload = &githubPushPayload{}
+	var payload = &githubPayload{}
 	if err := decoder.Decode(payload); err != nil {
 		logger.Error(err)
 		return http.StatusBadRequest, fmt.Sprintf("%d bad request", http.StatusBadRequest)
 	}
 
+	owner := payload.Repository.Owner.Name
+	if owner == "" {
+		owner = payload.Repository.Owner.Login
+	}
+
 	spec := &job.Spec{
-		RepoOwner: payload.Repository.Owner.Name,
+		RepoOwner: owner,
 		RepoName:  payload.Repository.Name,
 		GitRef:    payload.CommitSHA,
+		Trigger:   job.TriggerGitHub,
+	}
+
+	var kind, name string
+	switch event {
+	case "push":
+		if payload.Deleted {
+			return ignored("ref " + payload.Ref + " was deleted")
+		}
+		if kind, name = parseRef(payload.Ref); kind == RefKindTag {
+			// GitHub sends a create event for the same tag, which is what gets built
+			return ignored("tags are built from create events")
+		}
+	case "create":
+		if payload.RefType != "tag" {
+			// new branches are built from the push event that comes with them
+			return ignored("only tags are built from create events")
+		}
+		kind, name = RefKindTag, payload.Ref
+		spec.GitRef = payload.Ref
+	}
+
+	if code, body, ok := filterRef(spec, kind, name); !ok {
+		return code, body
 	}
 
 	return processJobHelper(spec, w, req)
@@ -27,6 +27,8 @@ type githubRepo struct {
 
 type githubRequest struct {
 	Commit     string     `json:"after"`
+	Ref        string     `json:"ref,omitempty"`
+	RefType    string     `json:"ref_type,omitempty"`
 	Repository githubRepo `json:"repository"`
 	Event      string     `json:"-"`
 	RawBody    string     `json:"-"`
@@ -63,19 +65,21 @@ func makeGithubRequest(options *githubRequest) (*http.Request, error) {
 
 var _ = Describe("Github", func() {
 	Context("when github request is unsupported", func() {
-		It("returns an error when event is not push", func() {
-			var testServer = newTestServer()
-			var recorder = httptest.NewRecorder()
-			req, err := makeGithubRequest(&githubRequest{
-				Event: "issue",
-			})
-			Expect(err).To(BeNil())
-			Expect(req).ToNot(BeNil())
-
-			testServer.ServeHTTP(recorder, req)
-
-			Expect(recorder.Code).To(Equal(400))
-			Expect(recorder.Body.String()).To(Equal("400 bad request"))
+		It("ignores events other than push and create", func() {
+			for _, event := range []string{"ping", "delete", "issues"} {
+				var testServer = newTestServer()
+				var recorder = httptest.NewRecorder()
+				req, err := makeGithubRequest(&githubRequest{
+					Event: event,
+				})
+				Expect(err).To(BeNil())
+				Expect(req).ToNot(BeNil())
+
+				testServer.ServeHTTP(recorder, req)
+
+				Expect(recorder.Code).To(Equal(200))
+				Expect(recorder.Body.String()).To(ContainSubstring(`"ignored":true`))
+			}
 		})
 		It("returns an error when JSON is invalid", func() {
 			var testServer = newTestServer()
@@ -116,4 +120,68 @@ var _ = Describe("Github", func() {
 			Expect(recorder.Body.String()).To(Equal("202 accepted"))
 		})
 	})
+	Context("when filtering by branch and tag", func() {
+		var testRepo githubRepo
+
+		serve := func(options *githubRequest) *httptest.ResponseRecorder {
+			var testServer = newTestServer()
+			var recorder = httptest.NewRecorder()
+			options.Repository = testRepo
+			req, err := makeGithubRequest(options)
+			Expect(err).To(BeNil())
+			testServer.ServeHTTP(recorder, req)
+			return recorder
+		}
+
+		BeforeEach(func() {
+			testRepo = githubRepo{Owner: githubOwner{Name: "testuser"}, Name: "testrepo"}
+			SetRefRules([]RefRule{
+				{
+					Repo:     "testuser/*",
+					Branches: RefFilter{Allow: []string{"master", "release/*"}},
+					Tags:     RefFilter{Allow: []string{"v*"}, Deny: []string{"v*-rc*"}},
+				},
+			})
+		})
+
+		AfterEach(func() {
+			SetRefRules(nil)
+		})
+
+		It("builds allowed branches", func() {
+			recorder := serve(&githubRequest{Event: "push", Commit: "abc123", Ref: "refs/heads/release/1.0"})
+			Expect(recorder.Code).To(Equal(202))
+		})
+
+		It("ignores branches that aren't allowed", func() {
+			recorder := serve(&githubRequest{Event: "push", Commit: "abc123", Ref: "refs/heads/feature/foo"})
+			Expect(recorder.Code).To(Equal(200))
+			Expect(recorder.Body.String()).To(MatchJSON(`{
+				"ignored": true,
+				"reason": "branch feature/foo is not allowed for testuser/testrepo"
+			}`))
+		})
+
+		It("builds allowed tags from create events", func() {
+			recorder := serve(&githubRequest{Event: "create", Ref: "v1.0.0", RefType: "tag"})
+			Expect(recorder.Code).To(Equal(202))
+		})
+
+		It("ignores denied tags", func() {
+			recorder := serve(&githubRequest{Event: "create", Ref: "v1.0.0-rc1", RefType: "tag"})
+			Expect(recorder.Code).To(Equal(200))
+			Expect(recorder.Body.String()).To(ContainSubstring("tag v1.0.0-rc1 is denied"))
+		})
+
+		It("ignores branch creation and tag pushes", func() {
+			Expect(serve(&githubRequest{Event: "create", Ref: "master", RefType: "branch"}).Code).To(Equal(200))
+			Expect(serve(&githubRequest{Event: "push", Commit: "abc123", Ref: "refs/tags/v1.0.0"}).Code).To(Equal(200))
+		})
+
+		It("builds everything in repos without a rule", func() {
+			testRepo.Owner.Name = "otheruser"
+			recorder := serve(&githubRequest{Event: "push", Commit: "abc123", Ref: "refs/heads/feature/foo"})
+			Expect(recorder.Code).To(Equal(202))
+		})
+	})
 })
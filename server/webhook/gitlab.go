@@ -0,0 +1,91 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/rafecolton/docker-builder/job"
+)
+
+var (
+	gitlabSupportedEvents = map[string]bool{
+		"Push Hook":     true,
+		"Tag Push Hook": true,
+	}
+)
+
+// gitlabDeletedSHA is the "after" SHA of a push that deletes a ref
+const gitlabDeletedSHA = "0000000000000000000000000000000000000000"
+
+type gitlabProject struct {
+	PathWithNamespace string `json:"path_with_namespace"`
+	GitHTTPURL        string `json:"git_http_url"`
+}
+
+type gitlabPushPayload struct {
+	Project     gitlabProject `json:"project"`
+	Ref         string        `json:"ref"`
+	After       string        `json:"after"`
+	CheckoutSHA string        `json:"checkout_sha"`
+}
+
+/*
+GitLab parses a GitLab push or tag push webhook HTTP request and returns a
+job.Spec.  The repo is cloned from the project's HTTP clone URL.
+*/
+func GitLab(w http.ResponseWriter, req *http.Request) (int, string) {
+	event := req.Header.Get("X-Gitlab-Event")
+	if !gitlabSupportedEvents[event] {
+		return ignored("event " + event + " is not built")
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		logger.Error(err)
+		return http.StatusBadRequest, fmt.Sprintf("%d bad request", http.StatusBadRequest)
+	}
+
+	var payload = &gitlabPushPayload{}
+	if err := json.Unmarshal(body, payload); err != nil {
+		logger.Error(err)
+		return http.StatusBadRequest, fmt.Sprintf("%d bad request", http.StatusBadRequest)
+	}
+
+	if payload.After == gitlabDeletedSHA {
+		return ignored("ref " + payload.Ref + " was deleted")
+	}
+
+	// nested groups are kept in the account, e.g. group/subgroup
+	slash := strings.LastIndex(payload.Project.PathWithNamespace, "/")
+	if slash < 0 {
+		logger.Errorf("GitLab project path %q has no namespace", payload.Project.PathWithNamespace)
+		return http.StatusBadRequest, fmt.Sprintf("%d bad request", http.StatusBadRequest)
+	}
+
+	spec := &job.Spec{
+		RepoOwner: payload.Project.PathWithNamespace[:slash],
+		RepoName:  payload.Project.PathWithNamespace[slash+1:],
+		GitRef:    payload.CheckoutSHA,
+		CloneURL:  payload.Project.GitHTTPURL,
+		Trigger:   job.TriggerGitLab,
+	}
+	if err := checkCloneURL(spec.CloneURL); err != nil {
+		logger.WithField("error", err).Error("GitLab payload has no usable clone URL")
+		return http.StatusBadRequest, fmt.Sprintf("%d bad request", http.StatusBadRequest)
+	}
+
+	kind, name := parseRef(payload.Ref)
+	if kind == RefKindTag {
+		spec.GitRef = name
+	}
+
+	if code, body, ok := filterRef(spec, kind, name); !ok {
+		return code, body
+	}
+
+	return processJobHelper(spec, w, req)
+}
@@ -0,0 +1,95 @@
+package webhook_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rafecolton/docker-builder/server/webhook"
+
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+)
+
+func makeGitLabRequest(event, body string) *http.Request {
+	req, _ := http.NewRequest(
+		"POST",
+		"http://localhost:5000/docker-build/gitlab",
+		bytes.NewReader([]byte(body)),
+	)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("X-Gitlab-Event", event)
+	return req
+}
+
+var _ = Describe("GitLab", func() {
+	var serve = func(req *http.Request) *httptest.ResponseRecorder {
+		var recorder = httptest.NewRecorder()
+		newTestServer().ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	It("accepts pushes", func() {
+		recorder := serve(makeGitLabRequest("Push Hook", `{
+			"ref": "refs/heads/master",
+			"after": "a427f16faa8e4d63f9fcaa4ec55e80765fd11b04",
+			"checkout_sha": "a427f16faa8e4d63f9fcaa4ec55e80765fd11b04",
+			"project": {
+				"path_with_namespace": "group/subgroup/testrepo",
+				"git_http_url": "https://gitlab.example.com/group/subgroup/testrepo.git"
+			}
+		}`))
+
+		Expect(recorder.Code).To(Equal(202))
+		Expect(recorder.Body.String()).To(Equal("202 accepted"))
+	})
+
+	It("ignores pushes that delete a ref", func() {
+		recorder := serve(makeGitLabRequest("Tag Push Hook", `{
+			"ref": "refs/tags/v1.0.0",
+			"after": "0000000000000000000000000000000000000000",
+			"project": {
+				"path_with_namespace": "group/testrepo",
+				"git_http_url": "https://gitlab.example.com/group/testrepo.git"
+			}
+		}`))
+
+		Expect(recorder.Code).To(Equal(200))
+		Expect(recorder.Body.String()).To(ContainSubstring(`"ignored":true`))
+	})
+
+	It("ignores unsupported events", func() {
+		recorder := serve(makeGitLabRequest("Issue Hook", `{}`))
+		Expect(recorder.Code).To(Equal(200))
+		Expect(recorder.Body.String()).To(ContainSubstring(`"ignored":true`))
+	})
+
+	It("returns an error for payloads without a usable clone URL", func() {
+		Expect(serve(makeGitLabRequest("Push Hook", `{
+			"ref": "refs/heads/master",
+			"checkout_sha": "a427f16faa8e4d63f9fcaa4ec55e80765fd11b04",
+			"project": {"path_with_namespace": "group/testrepo"}
+		}`)).Code).To(Equal(400))
+		Expect(serve(makeGitLabRequest("Push Hook", `{
+			"ref": "refs/heads/master",
+			"checkout_sha": "a427f16faa8e4d63f9fcaa4ec55e80765fd11b04",
+			"project": {"path_with_namespace": "group/testrepo", "git_http_url": "file:///etc/docker-builder"}
+		}`)).Code).To(Equal(400))
+	})
+
+	Context("when authenticating", func() {
+		It("only lets through requests with the right token", func() {
+			auth := GitLabAuth("s3cr3t")
+
+			req := makeGitLabRequest("Push Hook", `{}`)
+			req.Header.Set("X-Gitlab-Token", "s3cr3t")
+			recorder := httptest.NewRecorder()
+			auth(recorder, req)
+			Expect(recorder.Code).To(Equal(200))
+
+			req.Header.Set("X-Gitlab-Token", "wrong")
+			recorder = httptest.NewRecorder()
+			auth(recorder, req)
+			Expect(recorder.Code).To(Equal(401))
+		})
+	})
+})
@@ -0,0 +1,162 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/rafecolton/docker-builder/job"
+)
+
+// The kinds of refs that may be filtered by a RefRule
+const (
+	RefKindBranch = "branch"
+	RefKindTag    = "tag"
+)
+
+const (
+	branchRefPrefix = "refs/heads/"
+	tagRefPrefix    = "refs/tags/"
+)
+
+var refRules []RefRule
+
+/*
+RefRule decides which branches and tags of the repos matching Repo get built
+when a webhook is received for them.  Repo is a glob matched against
+"account/repo" (an empty Repo matches every repo), and the allow and deny
+lists in Branches and Tags are globs matched against the branch or tag name.
+*/
+type RefRule struct {
+	Repo     string    `json:"repo"`
+	Branches RefFilter `json:"branches"`
+	Tags     RefFilter `json:"tags"`
+}
+
+/*
+RefFilter is a pair of allow and deny lists of globs.  A name is allowed if it
+matches none of the deny globs and either the allow list is empty or the name
+matches one of the allow globs.
+*/
+type RefFilter struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+//SetRefRules sets the (global) branch and tag rules for the webhook package
+func SetRefRules(rules []RefRule) {
+	refRules = rules
+}
+
+/*
+LoadRefRules reads a JSON array of rules from the file at path, checking that
+every glob in them is valid.
+*/
+func LoadRefRules(path string) ([]RefRule, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []RefRule
+	if err := json.Unmarshal(raw, &rules); err != nil {
+		return nil, err
+	}
+
+	for _, rule := range rules {
+		patterns := []string{rule.Repo}
+		for _, filter := range []RefFilter{rule.Branches, rule.Tags} {
+			patterns = append(patterns, filter.Allow...)
+			patterns = append(patterns, filter.Deny...)
+		}
+		for _, pattern := range patterns {
+			if _, err := globMatch(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid glob %q: %v", pattern, err)
+			}
+		}
+	}
+
+	return rules, nil
+}
+
+/*
+refAllowed checks the ref against the first rule whose Repo matches the repo.
+If no rule matches, every ref is allowed.  When the ref is not allowed, the
+reason is returned as well.
+*/
+func refAllowed(rules []RefRule, account, repo, kind, name string) (bool, string) {
+	fullName := account + "/" + repo
+
+	for _, rule := range rules {
+		if rule.Repo != "" {
+			if matched, _ := globMatch(rule.Repo, fullName); !matched {
+				continue
+			}
+		}
+
+		filter := rule.Branches
+		if kind == RefKindTag {
+			filter = rule.Tags
+		}
+
+		for _, pattern := range filter.Deny {
+			if matched, _ := globMatch(pattern, name); matched {
+				return false, fmt.Sprintf("%s %s is denied for %s", kind, name, fullName)
+			}
+		}
+
+		if len(filter.Allow) == 0 {
+			return true, ""
+		}
+
+		for _, pattern := range filter.Allow {
+			if matched, _ := globMatch(pattern, name); matched {
+				return true, ""
+			}
+		}
+
+		return false, fmt.Sprintf("%s %s is not allowed for %s", kind, name, fullName)
+	}
+
+	return true, ""
+}
+
+// parseRef splits a full ref name (e.g. refs/heads/master) into its kind and
+// short name.  The kind is empty if the ref is neither a branch nor a tag.
+func parseRef(ref string) (kind, name string) {
+	switch {
+	case strings.HasPrefix(ref, branchRefPrefix):
+		return RefKindBranch, strings.TrimPrefix(ref, branchRefPrefix)
+	case strings.HasPrefix(ref, tagRefPrefix):
+		return RefKindTag, strings.TrimPrefix(ref, tagRefPrefix)
+	}
+	return "", ref
+}
+
+/*
+filterRef returns the "ignored" response for the ref if the ref rules don't
+allow it to be built, or ok == true if they do.  Refs of an unknown kind are
+always allowed.  The name of a branch is recorded on the spec, so that the job
+supersedes older jobs for the same branch.
+*/
+func filterRef(spec *job.Spec, kind, name string) (code int, body string, ok bool) {
+	if kind == RefKindBranch {
+		spec.Branch = name
+	}
+	if kind == "" {
+		return 0, "", true
+	}
+	if allowed, reason := refAllowed(refRules, spec.RepoOwner, spec.RepoName, kind, name); !allowed {
+		code, body = ignored(reason)
+		return code, body, false
+	}
+	return 0, "", true
+}
+
+// globMatch is path.Match, so `*` does not match a `/` (e.g. `release/*`
+// matches release/1.0 but `*` does not)
+func globMatch(pattern, name string) (bool, error) {
+	return path.Match(pattern, name)
+}
@@ -25,6 +25,7 @@ type travisRepository struct {
 type travisPayload struct {
 	Repository  travisRepository `json:"repository"`
 	CommitSHA   string           `json:"commit"`
+	Branch      string           `json:"branch"`
 	BuildStatus int              `json:"status"`
 	BuildType   string           `json:"type"`
 }
@@ -61,6 +62,8 @@ func Travis(w http.ResponseWriter, req *http.Request) (int, string) {
 		RepoOwner: payload.Repository.Owner,
 		RepoName:  payload.Repository.Name,
 		GitRef:    payload.CommitSHA,
+		Branch:    payload.Branch,
+		Trigger:   job.TriggerTravis,
 	}
 
 	return processJobHelper(spec, w, req)
@@ -0,0 +1,94 @@
+package webhook
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/moby/moby/pkg/archive"
+
+	"github.com/rafecolton/docker-builder/job"
+)
+
+// MaxUploadSize is the largest build context that may be uploaded, in bytes
+const MaxUploadSize = 1 << 30
+
+// the content types of build contexts uploaded as the whole request body
+var tarContentTypes = map[string]bool{
+	"application/x-tar":  true,
+	"application/gzip":   true,
+	"application/x-gzip": true,
+}
+
+/*
+uploadSpec reads the spec for a job whose build context is being uploaded, or
+returns a nil spec if the request isn't an upload.  The build context is left
+to be read from spec.Upload.
+
+Uploads are either multipart/form-data requests, with an optional "spec" part
+containing the same JSON as a regular request followed by a "context" part
+containing the tar archive, or requests whose body is the tar archive, with
+the spec fields given as query parameters.
+*/
+func uploadSpec(w http.ResponseWriter, req *http.Request) (*job.Spec, error) {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+
+	if tarContentTypes[mediaType] {
+		query := req.URL.Query()
+		sync, _ := strconv.ParseBool(query.Get("sync"))
+		return &job.Spec{
+			Bobfile:   query.Get("bobfile"),
+			RepoOwner: query.Get("account"),
+			RepoName:  query.Get("repo"),
+			GitRef:    query.Get("ref"),
+			Sync:      sync,
+			Upload:    http.MaxBytesReader(w, req.Body, MaxUploadSize),
+		}, nil
+	}
+
+	if mediaType != "multipart/form-data" {
+		return nil, nil
+	}
+
+	req.Body = http.MaxBytesReader(w, req.Body, MaxUploadSize)
+	reader, err := req.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	var spec = &job.Spec{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("upload has no context part")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch part.FormName() {
+		case "spec":
+			raw, err := ioutil.ReadAll(part)
+			if err != nil {
+				return nil, err
+			}
+			if spec, err = job.NewSpec(raw); err != nil {
+				return nil, err
+			}
+		case "context":
+			// the rest of the request is the build context itself, so it
+			// has to be the last part
+			spec.Upload = part
+			return spec, nil
+		}
+	}
+}
+
+// extractUpload extracts the uploaded build context (which may be compressed)
+// into dest
+func extractUpload(upload io.Reader, dest string) error {
+	return archive.Untar(upload, dest, &archive.TarOptions{NoLchown: true})
+}
@@ -2,14 +2,20 @@ package webhook
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"sync"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/modcloth/go-fileutils"
 	"github.com/onsi/gocleanup"
+	"github.com/rafecolton/vauth"
 
 	"github.com/rafecolton/docker-builder/job"
+	"github.com/rafecolton/docker-builder/tokens"
 )
 
 const (
@@ -29,6 +35,14 @@ const (
 var logger *logrus.Logger
 var apiToken string
 var testMode bool
+var dedupJobs bool
+var basicAuthUsername, basicAuthPassword string
+
+// dedupLock makes looking for a duplicate and creating the job a single step
+var dedupLock sync.Mutex
+
+// gocleanup isn't safe for concurrent use, but requests are handled concurrently
+var cleanupLock sync.Mutex
 
 //Logger sets the (global) logger for the webhook package
 func Logger(l *logrus.Logger) {
@@ -40,12 +54,107 @@ func APIToken(t string) {
 	apiToken = t
 }
 
+/*
+BasicAuth sets the (global) basic auth credentials of the server, so that jobs
+submitted with them record the username as their submitter
+*/
+func BasicAuth(username, password string) {
+	basicAuthUsername = username
+	basicAuthPassword = password
+}
+
+/*
+DedupJobs sets whether or not a request for the same account, repo, commit and
+Bobfile as a job that hasn't finished yet gets that job instead of a new one
+*/
+func DedupJobs(b bool) {
+	dedupJobs = b
+}
+
 //TestMode sets the (global) testMode variable for the webhook package
 func TestMode(b bool) {
 	testMode = b
 }
 
+/*
+ignored is the response for a webhook that was received successfully but
+doesn't result in a job.  It's still a 2xx so that the sender doesn't treat
+the hook as failing.
+*/
+func ignored(reason string) (int, string) {
+	if logger != nil {
+		logger.WithField("reason", reason).Info("ignoring webhook")
+	}
+
+	retBytes, err := json.Marshal(map[string]interface{}{
+		"ignored": true,
+		"reason":  reason,
+	})
+	if err != nil {
+		return 200, "200 ignored"
+	}
+
+	return 200, string(retBytes)
+}
+
+/*
+checkCloneURL makes sure that a clone URL taken from a webhook payload is an
+https or ssh URL.  Whoever sends the webhook picks the URL, so it mustn't be
+able to point the server at anything else it can read, such as a file:// repo.
+*/
+func checkCloneURL(raw string) error {
+	if raw == "" {
+		return errors.New("no clone URL")
+	}
+
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+
+	if (parsed.Scheme != "https" && parsed.Scheme != "ssh") || parsed.Host == "" {
+		return fmt.Errorf("%q is not an https or ssh clone URL", raw)
+	}
+
+	return nil
+}
+
+/*
+checkTokenRepo makes sure that a token that's limited to certain repos is only
+used to build them.  The account and repo of the spec are only labels, so a
+clone URL has to be one the token may use as well: an https or ssh URL (never
+a repo on the server itself) of an allowed repo.
+*/
+func checkTokenRepo(token *tokens.Token, spec *job.Spec) error {
+	if !token.Allows(spec.RepoOwner, spec.RepoName) {
+		return errors.New("token may not be used for " + spec.RepoOwner + "/" + spec.RepoName)
+	}
+
+	if len(token.Repos) == 0 || spec.CloneURL == "" {
+		return nil
+	}
+
+	if err := checkCloneURL(spec.CloneURL); err != nil {
+		return fmt.Errorf("token may not be used with clone_url %q", spec.CloneURL)
+	}
+
+	if account, repo := spec.ClonedRepo(); !token.Allows(account, repo) {
+		return errors.New("token may not be used for " + account + "/" + repo)
+	}
+
+	return nil
+}
+
 func processJobHelper(spec *job.Spec, w http.ResponseWriter, req *http.Request) (int, string) {
+	// an API token may be limited to certain repos, and is recorded on the job
+	if token := tokens.FromRequest(req); token != nil {
+		if err := checkTokenRepo(token, spec); err != nil {
+			retBytes, _ := json.Marshal(map[string]string{"error": err.Error()})
+			return 403, string(retBytes)
+		}
+		spec.TokenName = token.Name
+	}
+
 	// If tests are running, don't actually attempt to build containers, just return success.
 	// This is meant to allow testing ot the HTTP interactions for the webhooks
 	if testMode {
@@ -64,9 +173,22 @@ func processJobHelper(spec *job.Spec, w http.ResponseWriter, req *http.Request)
 		return 500, "500 internal server error"
 	}
 
+	cleanupLock.Lock()
 	gocleanup.Register(func() {
 		fileutils.RmRF(workdir)
 	})
+	cleanupLock.Unlock()
+
+	if spec.Upload != nil {
+		spec.ContextDir = workdir + "/context"
+		if err := extractUpload(spec.Upload, spec.ContextDir); err != nil {
+			logger.WithField("error", err).Error("error extracting uploaded build context")
+			fileutils.RmRF(workdir)
+			return 400, "400 bad request"
+		}
+	}
+
+	spec.Submitter = submitter(req)
 
 	jobConfig := &job.Config{
 		Logger:         logger,
@@ -74,14 +196,18 @@ func processJobHelper(spec *job.Spec, w http.ResponseWriter, req *http.Request)
 		GitHubAPIToken: apiToken,
 	}
 
-	j := job.NewJob(jobConfig, spec, req)
+	j, deduplicated := newJob(jobConfig, spec, req)
+	if deduplicated {
+		fileutils.RmRF(workdir)
+		return duplicate(j, spec.Sync)
+	}
 
 	// if sync
 	if spec.Sync {
-		if err = j.Process(); err != nil {
+		if err = job.Run(j); err != nil {
 			return 417, `{"error": "` + err.Error() + `"}`
 		}
-		retBytes, err := json.Marshal(j)
+		retBytes, err := json.Marshal(j.Snapshot())
 		if err != nil {
 			return 417, `{"error": "` + err.Error() + `"}`
 		}
@@ -90,12 +216,78 @@ func processJobHelper(spec *job.Spec, w http.ResponseWriter, req *http.Request)
 	}
 
 	// if async
-	go j.Process()
+	job.Enqueue(j)
 
-	retBytes, err := json.Marshal(j)
+	retBytes, err := json.Marshal(j.Snapshot())
 	if err != nil {
 		return 409, `{"error": "` + err.Error() + `"}`
 	}
 
 	return AsyncSuccessCode, string(retBytes)
 }
+
+/*
+submitter is who the request was authenticated as: the name of its API token,
+or the basic auth username if it has the server's basic auth credentials.
+Nothing the request says about who sent it is used, since it can't be checked.
+*/
+func submitter(req *http.Request) string {
+	if token := tokens.FromRequest(req); token != nil {
+		return token.Name
+	}
+
+	un, pwd, ok := req.BasicAuth()
+	if ok && basicAuthUsername != "" && basicAuthPassword != "" &&
+		vauth.SecureCompare(un, basicAuthUsername) && vauth.SecureCompare(pwd, basicAuthPassword) {
+		return un
+	}
+
+	return ""
+}
+
+/*
+newJob creates a job for spec, unless jobs are being deduplicated and there is
+already one in flight for the same build, in which case that job is returned
+instead (and deduplicated is true).
+*/
+func newJob(cfg *job.Config, spec *job.Spec, req *http.Request) (j *job.Job, deduplicated bool) {
+	if !dedupJobs {
+		return job.NewJob(cfg, spec, req), false
+	}
+
+	dedupLock.Lock()
+	defer dedupLock.Unlock()
+
+	if existing := job.FindDuplicate(spec); existing != nil {
+		logger.WithFields(logrus.Fields{
+			"job_id":  existing.ID,
+			"account": spec.RepoOwner,
+			"repo":    spec.RepoName,
+			"ref":     spec.GitRef,
+			"trigger": spec.Trigger,
+		}).Info("deduplicated job")
+		return existing, true
+	}
+
+	return job.NewJob(cfg, spec, req), false
+}
+
+/*
+duplicate is the response for a request that was deduplicated into j.  j is
+still in flight, so a sync request gets the async code rather than a response
+that looks like the build is done.
+*/
+func duplicate(j *job.Job, sync bool) (int, string) {
+	retBytes, err := json.Marshal(struct {
+		*job.Job
+		Deduplicated bool `json:"deduplicated"`
+	}{j.Snapshot(), true})
+	if err != nil {
+		return 409, `{"error": "` + err.Error() + `"}`
+	}
+
+	if sync {
+		return AsyncSuccessCode, string(retBytes)
+	}
+	return 200, string(retBytes)
+}
@@ -23,6 +23,9 @@ func newTestServer() (testServer *martini.ClassicMartini) {
 
 	testServer.Post("/docker-build/github", Github)
 	testServer.Post("/docker-build/travis", Travis)
+	testServer.Post("/docker-build/gitlab", GitLab)
+	testServer.Post("/docker-build/bitbucket", Bitbucket)
+	testServer.Post("/docker-build/gitea", Gitea)
 	testServer.Post("/docker-build", DockerBuild)
 	return
 }
@@ -0,0 +1,133 @@
+package tokens
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/go-martini/martini"
+	"github.com/rafecolton/vauth"
+)
+
+type contextKey struct{}
+
+// WithToken returns a copy of req that carries the token it was authenticated with
+func WithToken(req *http.Request, t *Token) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), contextKey{}, t))
+}
+
+/*
+FromRequest returns the token the request was authenticated with, or nil if it
+wasn't authenticated with a token (e.g. token auth is off, or basic auth was
+used), in which case there are no limits on what it may do.
+*/
+func FromRequest(req *http.Request) *Token {
+	t, _ := req.Context().Value(contextKey{}).(*Token)
+	return t
+}
+
+/*
+Auth returns a Handler that authenticates requests with a token that has scope,
+given as "Authorization: Bearer <token>".  Requests with the basic auth
+username and password (if they're set) are let through as well, with no
+limits.  The token is added to the request for the handlers that follow (see
+FromRequest).
+
+Writes a http.StatusUnauthorized if authentication fails, and a
+http.StatusForbidden if the token doesn't have scope.
+*/
+func Auth(scope, username, password string) martini.Handler {
+	return func(res http.ResponseWriter, req *http.Request, c martini.Context) {
+		if un, pwd, ok := req.BasicAuth(); ok && username != "" && password != "" {
+			if vauth.SecureCompare(un, username) && vauth.SecureCompare(pwd, password) {
+				return
+			}
+		}
+
+		t := Authenticate(bearer(req))
+		if t == nil {
+			res.Header().Set("WWW-Authenticate", `Bearer realm="docker-builder"`)
+			http.Error(res, "Not Authorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !t.HasScope(scope) {
+			http.Error(res, "Forbidden: token lacks the "+scope+" scope", http.StatusForbidden)
+			return
+		}
+
+		c.Map(WithToken(req, t))
+	}
+}
+
+func bearer(req *http.Request) string {
+	header := req.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// List is the handler function for listing the tokens (without their secrets).
+func List() (int, string) {
+	retBytes, err := json.Marshal(All())
+	if err != nil {
+		return 409, `{"error": "` + err.Error() + `"}`
+	}
+
+	return 200, string(retBytes)
+}
+
+/*
+Create is the handler function for adding a token.  The request body is the
+token's name, scopes and (optionally) repos, and the response includes the
+generated secret, which can't be retrieved again.
+*/
+func Create(req *http.Request) (int, string) {
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		return 400, "400 bad request"
+	}
+
+	var t Token
+	if err := json.Unmarshal(body, &t); err != nil {
+		return 400, "400 bad request"
+	}
+
+	secret, err := Add(t)
+	if err != nil {
+		code := 500
+		if _, ok := err.(*InvalidError); ok {
+			code = 400
+		} else if err == ErrExists {
+			code = 409
+		}
+		retBytes, _ := json.Marshal(map[string]string{"error": err.Error()})
+		return code, string(retBytes)
+	}
+
+	created := Authenticate(secret)
+	created.Token = secret
+
+	retBytes, err := json.Marshal(created)
+	if err != nil {
+		return 409, `{"error": "` + err.Error() + `"}`
+	}
+
+	return 201, string(retBytes)
+}
+
+// Revoke is the handler function for deleting a token.
+func Revoke(params martini.Params) (int, string) {
+	switch err := Delete(params["name"]); err {
+	case nil:
+		return 204, ""
+	case ErrNotFound:
+		return 404, `{"error": "` + err.Error() + `"}`
+	default:
+		return 500, `{"error": "` + err.Error() + `"}`
+	}
+}
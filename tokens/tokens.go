@@ -0,0 +1,350 @@
+/*
+Package tokens keeps track of the server's named API tokens.  Each token has
+scopes deciding which routes it may be used for, and may be limited to
+certain repos.  Tokens are loaded from (and saved back to) a JSON file, and
+may be managed through the admin routes.
+*/
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The scopes a token may have
+const (
+	ScopeJobsRead   = "jobs:read"
+	ScopeJobsWrite  = "jobs:write"
+	ScopeJobsCancel = "jobs:cancel"
+	ScopeMetrics    = "metrics:read"
+
+	// ScopeAdmin is for the admin routes, and implies every other scope
+	ScopeAdmin = "admin"
+)
+
+// Scopes are all of the valid scopes
+var Scopes = []string{ScopeJobsRead, ScopeJobsWrite, ScopeJobsCancel, ScopeMetrics, ScopeAdmin}
+
+var (
+	// ErrExists is returned when adding a token with the name of another one
+	ErrExists = errors.New("a token with that name already exists")
+
+	// ErrNotFound is returned when deleting a token that doesn't exist
+	ErrNotFound = errors.New("token not found")
+)
+
+var (
+	tokens    []*Token
+	tokenFile string
+	enabled   bool
+	lock      sync.RWMutex
+)
+
+/*
+Token is a named API token.  The secret is given either as Token or as the hex
+encoded SHA-256 of it (tokens created through the admin routes are only saved
+hashed).  Repos is an optional list of globs matched against "account/repo",
+in which case the token may only be used for jobs of the matching repos.
+*/
+type Token struct {
+	Name    string    `json:"name"`
+	Token   string    `json:"token,omitempty"`
+	SHA256  string    `json:"token_sha256,omitempty"`
+	Scopes  []string  `json:"scopes"`
+	Repos   []string  `json:"repos,omitempty"`
+	Created time.Time `json:"created,omitempty"`
+}
+
+// HasScope indicates whether or not the token may be used for scope
+func (t *Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows indicates whether or not the token may be used for jobs of account/repo
+func (t *Token) Allows(account, repo string) bool {
+	if len(t.Repos) == 0 {
+		return true
+	}
+	for _, pattern := range t.Repos {
+		if matched, _ := path.Match(pattern, account+"/"+repo); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// matches indicates whether or not secret is the token's secret
+func (t *Token) matches(secret string) bool {
+	if t.Token != "" && subtle.ConstantTimeCompare([]byte(t.Token), []byte(secret)) == 1 {
+		return true
+	}
+	return t.SHA256 != "" && subtle.ConstantTimeCompare([]byte(t.SHA256), []byte(hash(secret))) == 1
+}
+
+// redacted is a copy of the token without its secret
+func (t *Token) redacted() Token {
+	ret := *t
+	ret.Token = ""
+	ret.SHA256 = ""
+	ret.Scopes = append([]string{}, t.Scopes...)
+	ret.Repos = append([]string(nil), t.Repos...)
+	return ret
+}
+
+// InvalidError is returned for a token that is missing something or has an
+// invalid scope or repo glob
+type InvalidError struct {
+	msg string
+}
+
+func (e *InvalidError) Error() string { return e.msg }
+
+func invalid(format string, args ...interface{}) error {
+	return &InvalidError{msg: fmt.Sprintf(format, args...)}
+}
+
+func (t *Token) validate() error {
+	if t.Name == "" {
+		return invalid("tokens must each have a name")
+	}
+	if t.Token == "" && t.SHA256 == "" {
+		return invalid("token %q must have a token or token_sha256", t.Name)
+	}
+	if len(t.Scopes) == 0 {
+		return invalid("token %q must have at least one scope", t.Name)
+	}
+	for _, scope := range t.Scopes {
+		if !validScope(scope) {
+			return invalid("token %q has invalid scope %q", t.Name, scope)
+		}
+	}
+	for _, pattern := range t.Repos {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return invalid("token %q has invalid repo glob %q: %v", t.Name, pattern, err)
+		}
+	}
+	return nil
+}
+
+func validScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Load reads a JSON array of tokens from the file at path, checking that they
+are valid and that their names are unique.  A file that doesn't exist yet has
+no tokens in it.
+*/
+func Load(path string) ([]*Token, error) {
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return []*Token{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []*Token
+	if err := json.Unmarshal(raw, &ret); err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for _, t := range ret {
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("%s: more than one token is named %q", path, t.Name)
+		}
+		names[t.Name] = true
+	}
+
+	return ret, nil
+}
+
+/*
+Configure turns on token auth with the tokens in the file at path, which is
+also where tokens added or deleted through the admin routes are saved.  An
+empty path turns token auth off.
+*/
+func Configure(path string) error {
+	var loaded []*Token
+	if path != "" {
+		var err error
+		if loaded, err = Load(path); err != nil {
+			return err
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	tokens = loaded
+	tokenFile = path
+	enabled = path != ""
+	return nil
+}
+
+// Enabled indicates whether or not token auth is turned on
+func Enabled() bool {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return enabled
+}
+
+// Authenticate returns the token whose secret is secret, or nil if there isn't one
+func Authenticate(secret string) *Token {
+	if secret == "" {
+		return nil
+	}
+
+	lock.RLock()
+	defer lock.RUnlock()
+
+	for _, t := range tokens {
+		if t.matches(secret) {
+			ret := t.redacted()
+			return &ret
+		}
+	}
+	return nil
+}
+
+// All returns all of the tokens (without their secrets), sorted by name
+func All() []Token {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	ret := make([]Token, 0, len(tokens))
+	for _, t := range tokens {
+		ret = append(ret, t.redacted())
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+/*
+Add generates a secret for a new token with t's name, scopes and repos, saves
+it and returns the secret, which isn't kept anywhere (only its hash is).
+*/
+func Add(t Token) (string, error) {
+	secret, err := generate()
+	if err != nil {
+		return "", err
+	}
+
+	t.Token = ""
+	t.SHA256 = hash(secret)
+	t.Created = time.Now().UTC()
+	if err := t.validate(); err != nil {
+		return "", err
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	for _, existing := range tokens {
+		if existing.Name == t.Name {
+			return "", ErrExists
+		}
+	}
+
+	all := append(append([]*Token{}, tokens...), &t)
+	if err := save(all); err != nil {
+		return "", err
+	}
+	tokens = all
+
+	return secret, nil
+}
+
+// Delete deletes the token named name, so that it can no longer be used
+func Delete(name string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	var kept []*Token
+	for _, t := range tokens {
+		if t.Name != name {
+			kept = append(kept, t)
+		}
+	}
+	if len(kept) == len(tokens) {
+		return ErrNotFound
+	}
+
+	if err := save(kept); err != nil {
+		return err
+	}
+	tokens = kept
+
+	return nil
+}
+
+// save writes all to the token file (if there is one), replacing it atomically
+func save(all []*Token) error {
+	if tokenFile == "" {
+		return nil
+	}
+
+	if all == nil {
+		all = []*Token{}
+	}
+	raw, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(tokenFile), filepath.Base(tokenFile)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(raw, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), tokenFile)
+}
+
+func generate() (string, error) {
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
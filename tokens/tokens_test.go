@@ -0,0 +1,234 @@
+package tokens_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-martini/martini"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rafecolton/docker-builder/tokens"
+)
+
+const tokenFile = `[
+  {"name": "ci", "token": "ci-secret", "scopes": ["jobs:read", "jobs:write"], "repos": ["foo/*"]},
+  {"name": "ops", "token_sha256": "%s", "scopes": ["admin"]}
+]`
+
+func sha(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+var _ = Describe("tokens", func() {
+	var (
+		dir  string
+		path string
+	)
+
+	write := func(contents string) {
+		ioutil.WriteFile(path, []byte(contents), 0600)
+	}
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "tokens")
+		path = filepath.Join(dir, "tokens.json")
+		write(strings.Replace(tokenFile, "%s", sha("ops-secret"), 1))
+		Expect(Configure(path)).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Configure("")
+		os.RemoveAll(dir)
+	})
+
+	Describe("Load", func() {
+		It("has no tokens if the file doesn't exist yet", func() {
+			tokens, err := Load(filepath.Join(dir, "missing.json"))
+			Expect(err).To(BeNil())
+			Expect(tokens).To(BeEmpty())
+		})
+
+		It("rejects invalid tokens", func() {
+			for _, contents := range []string{
+				`[{"token": "x", "scopes": ["jobs:read"]}]`,
+				`[{"name": "a", "scopes": ["jobs:read"]}]`,
+				`[{"name": "a", "token": "x", "scopes": []}]`,
+				`[{"name": "a", "token": "x", "scopes": ["jobs:delete"]}]`,
+				`[{"name": "a", "token": "x", "scopes": ["jobs:read"], "repos": ["foo/["]}]`,
+				`[{"name": "a", "token": "x", "scopes": ["jobs:read"]}, {"name": "a", "token": "y", "scopes": ["jobs:read"]}]`,
+			} {
+				write(contents)
+				_, err := Load(path)
+				Expect(err).ToNot(BeNil(), contents)
+			}
+		})
+	})
+
+	It("turns token auth on and off", func() {
+		Expect(Enabled()).To(BeTrue())
+		Configure("")
+		Expect(Enabled()).To(BeFalse())
+		Expect(Authenticate("ci-secret")).To(BeNil())
+	})
+
+	It("authenticates tokens given as is or as their hash", func() {
+		ci := Authenticate("ci-secret")
+		Expect(ci).ToNot(BeNil())
+		Expect(ci.Name).To(Equal("ci"))
+		Expect(ci.Token).To(BeEmpty())
+
+		Expect(Authenticate("ops-secret").Name).To(Equal("ops"))
+		Expect(Authenticate(sha("ops-secret"))).To(BeNil())
+		Expect(Authenticate("nope")).To(BeNil())
+		Expect(Authenticate("")).To(BeNil())
+	})
+
+	It("checks scopes, with admin implying every other scope", func() {
+		ci := Authenticate("ci-secret")
+		Expect(ci.HasScope(ScopeJobsWrite)).To(BeTrue())
+		Expect(ci.HasScope(ScopeJobsCancel)).To(BeFalse())
+		Expect(ci.HasScope(ScopeAdmin)).To(BeFalse())
+
+		ops := Authenticate("ops-secret")
+		Expect(ops.HasScope(ScopeJobsCancel)).To(BeTrue())
+		Expect(ops.HasScope(ScopeMetrics)).To(BeTrue())
+	})
+
+	It("limits tokens to the repos matching their globs", func() {
+		ci := Authenticate("ci-secret")
+		Expect(ci.Allows("foo", "bar")).To(BeTrue())
+		Expect(ci.Allows("baz", "bar")).To(BeFalse())
+
+		Expect(Authenticate("ops-secret").Allows("baz", "bar")).To(BeTrue())
+	})
+
+	It("adds tokens, saving only their hash", func() {
+		secret, err := Add(Token{Name: "jenkins", Scopes: []string{ScopeJobsRead}})
+		Expect(err).To(BeNil())
+		Expect(secret).ToNot(BeEmpty())
+		Expect(Authenticate(secret).Name).To(Equal("jenkins"))
+
+		raw, _ := ioutil.ReadFile(path)
+		Expect(string(raw)).To(ContainSubstring(sha(secret)))
+		Expect(string(raw)).ToNot(ContainSubstring(secret + `"`))
+
+		// still there after a restart
+		Expect(Configure(path)).To(BeNil())
+		Expect(Authenticate(secret).Name).To(Equal("jenkins"))
+
+		_, err = Add(Token{Name: "jenkins", Scopes: []string{ScopeJobsRead}})
+		Expect(err).To(Equal(ErrExists))
+
+		_, err = Add(Token{Name: "bad", Scopes: []string{"everything"}})
+		Expect(err).To(BeAssignableToTypeOf(&InvalidError{}))
+	})
+
+	It("deletes tokens", func() {
+		Expect(Delete("ci")).To(BeNil())
+		Expect(Authenticate("ci-secret")).To(BeNil())
+		Expect(Delete("ci")).To(Equal(ErrNotFound))
+
+		Expect(Configure(path)).To(BeNil())
+		Expect(Authenticate("ci-secret")).To(BeNil())
+		Expect(Authenticate("ops-secret")).ToNot(BeNil())
+	})
+
+	Describe("Auth", func() {
+		var server *martini.ClassicMartini
+
+		request := func(method, url, auth string, body []byte) *httptest.ResponseRecorder {
+			req, _ := http.NewRequest(method, url, bytes.NewReader(body))
+			if auth != "" {
+				req.Header.Set("Authorization", auth)
+			}
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			return recorder
+		}
+
+		BeforeEach(func() {
+			server = martini.Classic()
+			whoami := func(req *http.Request) string {
+				if t := FromRequest(req); t != nil {
+					return t.Name
+				}
+				return "nobody"
+			}
+			server.Get("/jobs", Auth(ScopeJobsRead, "user", "pass"), whoami)
+			server.Delete("/jobs", Auth(ScopeJobsCancel, "user", "pass"), whoami)
+			server.Group("/admin", func(r martini.Router) {
+				r.Get("/tokens", List)
+				r.Post("/tokens", Create)
+				r.Delete("/tokens/:name", Revoke)
+			}, Auth(ScopeAdmin, "", ""))
+		})
+
+		It("lets through tokens with the scope, for the handlers that follow", func() {
+			recorder := request("GET", "/jobs", "Bearer ci-secret", nil)
+			Expect(recorder.Code).To(Equal(200))
+			Expect(recorder.Body.String()).To(Equal("ci"))
+		})
+
+		It("rejects requests without a valid token", func() {
+			Expect(request("GET", "/jobs", "", nil).Code).To(Equal(401))
+			Expect(request("GET", "/jobs", "Bearer nope", nil).Code).To(Equal(401))
+		})
+
+		It("forbids tokens without the scope", func() {
+			Expect(request("DELETE", "/jobs", "Bearer ci-secret", nil).Code).To(Equal(403))
+		})
+
+		It("lets through the basic auth username and password, without a token", func() {
+			recorder := request("DELETE", "/jobs", "Basic dXNlcjpwYXNz", nil)
+			Expect(recorder.Code).To(Equal(200))
+			Expect(recorder.Body.String()).To(Equal("nobody"))
+
+			Expect(request("DELETE", "/jobs", "Basic dXNlcjpub3Bl", nil).Code).To(Equal(401))
+		})
+
+		It("manages tokens through the admin routes", func() {
+			recorder := request("POST", "/admin/tokens", "Bearer ops-secret",
+				[]byte(`{"name": "jenkins", "scopes": ["jobs:read"], "repos": ["foo/bar"]}`))
+			Expect(recorder.Code).To(Equal(201))
+
+			var created Token
+			json.Unmarshal(recorder.Body.Bytes(), &created)
+			Expect(created.Name).To(Equal("jenkins"))
+			Expect(created.Token).ToNot(BeEmpty())
+			Expect(created.Repos).To(Equal([]string{"foo/bar"}))
+
+			Expect(request("GET", "/jobs", "Bearer "+created.Token, nil).Body.String()).To(Equal("jenkins"))
+
+			recorder = request("GET", "/admin/tokens", "Bearer ops-secret", nil)
+			var listed []Token
+			json.Unmarshal(recorder.Body.Bytes(), &listed)
+			Expect(listed).To(HaveLen(3))
+			Expect(listed[1].Name).To(Equal("jenkins"))
+			Expect(recorder.Body.String()).ToNot(ContainSubstring(created.Token))
+			Expect(recorder.Body.String()).ToNot(ContainSubstring("ci-secret"))
+
+			Expect(request("POST", "/admin/tokens", "Bearer ops-secret",
+				[]byte(`{"name": "jenkins", "scopes": ["jobs:read"]}`)).Code).To(Equal(409))
+			Expect(request("POST", "/admin/tokens", "Bearer ops-secret",
+				[]byte(`{"name": "bad", "scopes": ["everything"]}`)).Code).To(Equal(400))
+
+			Expect(request("DELETE", "/admin/tokens/jenkins", "Bearer ops-secret", nil).Code).To(Equal(204))
+			Expect(request("DELETE", "/admin/tokens/jenkins", "Bearer ops-secret", nil).Code).To(Equal(404))
+			Expect(request("GET", "/jobs", "Bearer "+created.Token, nil).Code).To(Equal(401))
+		})
+
+		It("keeps tokens without the admin scope out of the admin routes", func() {
+			Expect(request("GET", "/admin/tokens", "Bearer ci-secret", nil).Code).To(Equal(403))
+		})
+	})
+})